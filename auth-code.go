@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+
+	// How long to wait for the user to finish logging in, if ctx doesn't end it sooner
+	authCodeLoginTimeout = 5 * time.Minute
+)
+
+// Authenticate as a user with the OIDC authorization code flow and PKCE, for desktop tools
+// that shouldn't hold a client secret. clientId must be a public client that allows
+// redirects to http://127.0.0.1 on any port. When the client first needs an access token,
+// it starts a listener on a loopback port and passes the IdP's login URL to openBrowser;
+// once the user has logged in, the browser is redirected back to the listener with a
+// code the client exchanges for tokens. A nil openBrowser prints the URL to stderr and
+// tries to open the system browser. Use WithTokenCache to keep the login between runs.
+// Only supported by the native backend.
+func WithAuthorizationCode(orgName, clientId string, openBrowser func(authURL string) error) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthAuthorizationCode
+		cfg.orgName, cfg.clientId = orgName, clientId
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+		cfg.openBrowser = openBrowser
+	}
+}
+
+func openSystemBrowser(authURL string) error {
+	fmt.Fprintf(os.Stderr, "To sign in, open %s\n", authURL)
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", authURL)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", authURL)
+	default:
+		cmd = exec.Command("xdg-open", authURL)
+	}
+	//The URL is printed, so a missing opener isn't an error
+	if cmd.Start() == nil {
+		go cmd.Wait() //nolint:errcheck
+	}
+	return nil
+}
+
+type authCodeLogin struct {
+	authURL     string
+	openBrowser func(authURL string) error
+}
+
+func newOIDCAuthorizationCode(oidcURL, orgName, clientId string, openBrowser func(string) error, httpClient *http.Client) *oidcCredentials {
+	if openBrowser == nil {
+		openBrowser = openSystemBrowser
+	}
+	return &oidcCredentials{
+		tokenURL:   oidcTokenURL(oidcURL, orgName),
+		form:       url.Values{"client_id": {clientId}},
+		httpClient: httpClient,
+		lock:       make(chan struct{}, 1),
+		interactive: &authCodeLogin{
+			authURL:     oidcRealmURL(oidcURL, orgName) + "/protocol/openid-connect/auth",
+			openBrowser: openBrowser,
+		},
+	}
+}
+
+// authCodeResult is what the IdP redirected the browser back with.
+type authCodeResult struct {
+	code string
+	err  error
+}
+
+func (login *authCodeLogin) login(ctx context.Context, creds *oidcCredentials, clientPublicKeyPEM string) error {
+	ctx, cancel := context.WithTimeout(ctx, authCodeLoginTimeout)
+	defer cancel()
+
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return err
+	}
+	state, err := randomURLString(16)
+	if err != nil {
+		return err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	//RFC 8252: the IP literal rather than localhost, which might not resolve to loopback
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return &TDFError{Kind: ErrFailure, Op: "OIDC login", Err: err}
+	}
+	redirectURI := "http://" + listener.Addr().String() + "/callback"
+
+	results := make(chan authCodeResult, 1)
+	server := &http.Server{
+		Handler:           authCodeCallback(state, results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener) //nolint:errcheck
+	defer server.Close()
+
+	authURL := login.authURL + "?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             creds.form["client_id"],
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid"},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	if err := login.openBrowser(authURL); err != nil {
+		return &TDFError{Kind: ErrFailure, Op: "OIDC login", Err: err}
+	}
+
+	var result authCodeResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	return creds.fetch(ctx, url.Values{
+		"grant_type":    {grantTypeAuthorizationCode},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     creds.form["client_id"],
+		"code_verifier": {verifier},
+	}, clientPublicKeyPEM)
+}
+
+// authCodeCallback handles the IdP's redirect back to the loopback listener, sending
+// the first result with the right state to results.
+func authCodeCallback(state string, results chan<- authCodeResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		//Anything else on the machine can reach the listener, so ignore requests we didn't start
+		if query.Get("state") != state {
+			http.Error(w, "Unexpected login state", http.StatusBadRequest)
+			return
+		}
+
+		var result authCodeResult
+		switch {
+		case query.Get("error") != "":
+			oidcErr := &oidcError{Code: query.Get("error"), Description: query.Get("error_description")}
+			kind := ErrFailure
+			if oidcErr.Code == "access_denied" {
+				kind = ErrAccessDenied
+			}
+			result.err = &TDFError{Kind: kind, Op: "OIDC login", Err: oidcErr}
+		case query.Get("code") == "":
+			result.err = &TDFError{Kind: ErrFailure, Op: "OIDC login", Err: errors.New("redirect has no authorization code")}
+		default:
+			result.code = query.Get("code")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if result.err != nil {
+			fmt.Fprintf(w, "<html><body>Login failed: %s</body></html>", html.EscapeString(result.err.Error()))
+		} else {
+			fmt.Fprint(w, "<html><body>Login complete, you can close this window.</body></html>")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	return mux
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
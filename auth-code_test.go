@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAuthCodeIdP is a stand-in IdP for the authorization code flow. Its browser
+// logs the user in at once, and its token endpoint checks the PKCE verifier against
+// the challenge the login started with.
+type testAuthCodeIdP struct {
+	*httptest.Server
+	// Sent back to the client instead of a code, if set
+	loginError string
+
+	mu          sync.Mutex
+	challenge   string
+	redirectURI string
+}
+
+func newTestAuthCodeIdP(t *testing.T) *testAuthCodeIdP {
+	t.Helper()
+	idp := &testAuthCodeIdP{}
+	idp.Server = httptest.NewServer(http.HandlerFunc(idp.token))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testAuthCodeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/auth/realms/tdf/protocol/openid-connect/token" {
+		http.NotFound(w, r)
+		return
+	}
+	idp.mu.Lock()
+	challenge, redirectURI := idp.challenge, idp.redirectURI
+	idp.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("grant_type") != grantTypeAuthorizationCode || r.FormValue("code") != "auth-code" ||
+		r.FormValue("redirect_uri") != redirectURI || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidcErrorResponse{Error: "invalid_grant"}) //nolint:errcheck
+		return
+	}
+	json.NewEncoder(w).Encode(oidcTokenResponse{AccessToken: testAccessToken, TokenType: "Bearer", ExpiresIn: 300}) //nolint:errcheck
+}
+
+// openBrowser checks the login URL, then redirects to the client's listener the way
+// the IdP would once the user logged in - after a request with the wrong state,
+// which the listener must ignore.
+func (idp *testAuthCodeIdP) openBrowser(authURL string) error {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	if u.Path != "/auth/realms/tdf/protocol/openid-connect/auth" || query.Get("response_type") != "code" ||
+		query.Get("client_id") != "tdf-cli" || query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" || query.Get("state") == "" ||
+		!strings.HasPrefix(query.Get("redirect_uri"), "http://127.0.0.1:") {
+		return fmt.Errorf("unexpected login URL %s", authURL)
+	}
+	idp.mu.Lock()
+	idp.challenge, idp.redirectURI = query.Get("code_challenge"), query.Get("redirect_uri")
+	idp.mu.Unlock()
+
+	forged, err := http.Get(query.Get("redirect_uri") + "?" + url.Values{"code": {"forged-code"}, "state": {"wrong-state"}}.Encode())
+	if err != nil {
+		return err
+	}
+	forged.Body.Close()
+	if forged.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("callback with the wrong state answered %d", forged.StatusCode)
+	}
+
+	redirect := url.Values{"code": {"auth-code"}, "state": {query.Get("state")}}
+	if idp.loginError != "" {
+		redirect = url.Values{"error": {idp.loginError}, "state": {query.Get("state")}}
+	}
+	resp, err := http.Get(query.Get("redirect_uri") + "?" + redirect.Encode())
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestAuthorizationCodePKCE(t *testing.T) {
+	kas := newTestKAS(t)
+	idp := newTestAuthCodeIdP(t)
+	tdfClient, err := NewTDFClient(WithBackend(BackendNative), WithAuthorizationCode("tdf", "tdf-cli", idp.openBrowser),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	defer tdfClient.Close()
+	if err := encryptAndCheck(context.Background(), tdfClient, kas, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizationCodeLoginDenied(t *testing.T) {
+	kas := newTestKAS(t)
+	idp := newTestAuthCodeIdP(t)
+	idp.loginError = "access_denied"
+	tdfClient, err := NewTDFClient(WithBackend(BackendNative), WithAuthorizationCode("tdf", "tdf-cli", idp.openBrowser),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	defer tdfClient.Close()
+
+	// Encrypting needs no login, only the KAS public key
+	storage, err := NewTDFStorageString("plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	tdf, err := tdfClient.EncryptWithOptions(context.Background(), storage, EncryptOptions{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions() error = %v", err)
+	}
+	tdfStorage, err := NewTDFStorageBytes(tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdfStorage.Close()
+	if _, err := tdfClient.DecryptBytes(context.Background(), tdfStorage); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("DecryptBytes() error = %v, want ErrAccessDenied", err)
+	}
+}
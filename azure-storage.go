@@ -0,0 +1,159 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	azureAPIVersion = "2020-10-02"
+	// Size of each block of a blob uploaded as blocks. Blobs no bigger than one
+	// block are uploaded with a single request.
+	azureBlockSize = 8 << 20
+)
+
+// NewAzureBlobBackend returns a StorageBackend for an Azure Storage block blob.
+// blobURL is the URL of the blob, e.g. https://account.blob.core.windows.net/container/blob,
+// normally carrying a SAS token as its query. Without one, pass a Microsoft Entra ID
+// (Azure AD) access token as accessToken. Point blobURL at Azurite to use the emulator.
+func NewAzureBlobBackend(blobURL, accessToken string) (StorageBackend, error) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewAzureBlobBackend", Err: err}
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Path == "" {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewAzureBlobBackend", Err: fmt.Errorf("%q is not a blob URL", u.Redacted())}
+	}
+	return &azureBackend{blobURL: u, accessToken: accessToken, httpClient: &http.Client{Transport: storageTransport}}, nil
+}
+
+type azureBackend struct {
+	blobURL     *url.URL
+	accessToken string
+	httpClient  *http.Client
+}
+
+// Descriptor leaves out the query, so SAS signatures don't end up in logs.
+func (azure *azureBackend) Descriptor() string {
+	u := *azure.blobURL
+	u.RawQuery = ""
+	return "azure:" + u.String()
+}
+
+// do sends a request for the blob, adding params to its query alongside any SAS token.
+func (azure *azureBackend) do(ctx context.Context, method string, params url.Values, body []byte, header http.Header, op string) (*http.Response, error) {
+	u := *azure.blobURL
+	if len(params) > 0 {
+		query := u.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: op, Err: err}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if azure.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+azure.accessToken)
+	}
+	return doStorageRequest(azure.httpClient, req, op)
+}
+
+func (azure *azureBackend) Size(ctx context.Context) (int64, error) {
+	resp, err := azure.do(ctx, http.MethodHead, nil, nil, nil, "Azure get blob properties")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, newNetworkError("Azure get blob properties", err)
+	}
+	return size, nil
+}
+
+func (azure *azureBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := azure.do(ctx, http.MethodGet, nil, nil, nil, "Azure get blob")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (azure *azureBackend) ReadRange(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {httpRange(offset, length)}}
+	resp, err := azure.do(ctx, http.MethodGet, nil, nil, header, "Azure get blob")
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset)
+}
+
+func (azure *azureBackend) Create(ctx context.Context) (StorageWriter, error) {
+	return &chunkedWriter{uploader: &azureUpload{ctx: ctx, azure: azure}, partSize: azureBlockSize}, nil
+}
+
+// azureUpload stages each full block as it is written and commits the block list
+// at the end. Blocks that are never committed are discarded by Azure after a week.
+type azureUpload struct {
+	ctx      context.Context
+	azure    *azureBackend
+	blockIDs []string
+}
+
+func (up *azureUpload) uploadPart(part []byte) error {
+	//Block IDs must all be the same length
+	blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(up.blockIDs))))
+	params := url.Values{"comp": {"block"}, "blockid": {blockID}}
+	resp, err := up.azure.do(up.ctx, http.MethodPut, params, part, nil, "Azure put block")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	up.blockIDs = append(up.blockIDs, blockID)
+	return nil
+}
+
+func (up *azureUpload) finish(last []byte, parts int) error {
+	if parts == 0 {
+		header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+		resp, err := up.azure.do(up.ctx, http.MethodPut, nil, last, header, "Azure put blob")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if len(last) > 0 {
+		if err := up.uploadPart(last); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: up.blockIDs})
+	if err != nil {
+		return err
+	}
+	resp, err := up.azure.do(up.ctx, http.MethodPut, url.Values{"comp": {"blocklist"}}, body, nil, "Azure put block list")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (up *azureUpload) abort() {
+	up.blockIDs = nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAzure is a stand-in Azure Blob service holding one container's block blobs.
+// It only accepts requests carrying the test SAS signature.
+type testAzure struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	staged map[string]map[string][]byte
+	blocks int
+}
+
+func newTestAzure(t *testing.T) *testAzure {
+	t.Helper()
+	azure := &testAzure{blobs: make(map[string][]byte), staged: make(map[string]map[string][]byte)}
+	azure.Server = httptest.NewServer(http.HandlerFunc(azure.serve))
+	t.Cleanup(azure.Close)
+	return azure
+}
+
+func (azure *testAzure) serve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("sig") != "test-signature" || r.Header.Get("x-ms-version") != azureAPIVersion {
+		http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	azure.mu.Lock()
+	defer azure.mu.Unlock()
+	blob := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := azure.blobs[blob]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		if azure.staged[blob] == nil {
+			azure.staged[blob] = make(map[string][]byte)
+		}
+		azure.staged[blob][query.Get("blockid")] = body
+		azure.blocks++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, id := range list.Latest {
+			block, ok := azure.staged[blob][id]
+			if !ok || len(id) != len(list.Latest[0]) {
+				http.Error(w, "InvalidBlockList", http.StatusBadRequest)
+				return
+			}
+			data = append(data, block...)
+		}
+		azure.blobs[blob] = data
+		delete(azure.staged, blob)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-blob-type") == "BlockBlob":
+		azure.blobs[blob] = body
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestAzureBlobBackend(t *testing.T) {
+	idp, kas, azure := newTestIdP(t), newTestKAS(t), newTestAzure(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+
+	tests := []struct {
+		name   string
+		size   int
+		blocks int
+	}{
+		{"put blob", 1000, 0},
+		{"block list", azureBlockSize + azureBlockSize/2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobURL := azure.URL + "/container/" + strings.ReplaceAll(tt.name, " ", "-") + ".tdf?sv=2020-10-02&sig=test-signature"
+			backend, err := NewAzureBlobBackend(blobURL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc := backend.Descriptor(); strings.Contains(desc, "test-signature") {
+				t.Errorf("Descriptor() = %s, shows the SAS signature", desc)
+			}
+			azure.mu.Lock()
+			azure.blocks = 0
+			azure.mu.Unlock()
+
+			checkBackendRoundTrip(t, tdfClient, backend, bytes.Repeat([]byte("a"), tt.size))
+
+			azure.mu.Lock()
+			defer azure.mu.Unlock()
+			if azure.blocks != tt.blocks {
+				t.Errorf("staged %d blocks, want %d", azure.blocks, tt.blocks)
+			}
+		})
+	}
+}
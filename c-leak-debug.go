@@ -0,0 +1,87 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Setting this environment variable to any non-empty value turns on tracking of
+// the C memory and client-cpp objects this package allocates. It is read once, at
+// startup, and makes every allocation record its stack, so it's for debugging only.
+const leakDebugEnv = "OPENTDF_LEAK_DEBUG"
+
+var cAllocs = newAllocTracker(os.Getenv(leakDebugEnv) != "")
+
+// allocTracker records live C allocations, keyed by address, while leak debugging is on.
+type allocTracker struct {
+	enabled bool
+
+	mu   sync.Mutex
+	live map[uintptr]allocRecord
+}
+
+type allocRecord struct {
+	kind  string
+	stack string
+}
+
+func newAllocTracker(enabled bool) *allocTracker {
+	return &allocTracker{enabled: enabled, live: make(map[uintptr]allocRecord)}
+}
+
+func (tracker *allocTracker) track(ptr unsafe.Pointer, kind string) {
+	if !tracker.enabled || ptr == nil {
+		return
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.live[uintptr(ptr)] = allocRecord{kind: kind, stack: string(debug.Stack())}
+}
+
+func (tracker *allocTracker) untrack(ptr unsafe.Pointer) {
+	if !tracker.enabled || ptr == nil {
+		return
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.live, uintptr(ptr))
+}
+
+// reportFinalized warns that an object owning the allocation at ptr was garbage
+// collected without being closed, so its finalizer is about to free it.
+func (tracker *allocTracker) reportFinalized(ptr unsafe.Pointer, owner string) {
+	if !tracker.enabled || ptr == nil {
+		return
+	}
+	tracker.mu.Lock()
+	record, ok := tracker.live[uintptr(ptr)]
+	tracker.mu.Unlock()
+	if ok {
+		fmt.Fprintf(os.Stderr, "opentdf: %s was never closed; freeing its %s, allocated at:\n%s\n", owner, record.kind, record.stack)
+	}
+}
+
+// CheckCLeaks reports the C memory and client-cpp objects allocated by this package
+// that have not been freed, each with the stack that allocated it. Call it as a
+// process exits, once every TDFClient and TDFStorage should have been closed.
+// It only finds anything when built with cgo and run with OPENTDF_LEAK_DEBUG set,
+// and returns nil otherwise.
+func CheckCLeaks() error {
+	cAllocs.mu.Lock()
+	defer cAllocs.mu.Unlock()
+	if len(cAllocs.live) == 0 {
+		return nil
+	}
+
+	leaks := make([]string, 0, len(cAllocs.live))
+	for _, record := range cAllocs.live {
+		leaks = append(leaks, record.kind+" allocated at:\n"+record.stack)
+	}
+	sort.Strings(leaks)
+	return fmt.Errorf("%d C allocations were never freed:\n%s", len(leaks), strings.Join(leaks, "\n"))
+}
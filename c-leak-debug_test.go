@@ -0,0 +1,20 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestMain fails the run if the tests left C allocations unfreed, which it can only
+// find when run with OPENTDF_LEAK_DEBUG set.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if err := CheckCLeaks(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,34 @@
+//go:build !cgo
+
+package client
+
+import "errors"
+
+// Without cgo there is no client-cpp library to hand storage to, so TDFStorage
+// objects only carry their Go-side source and only the native backend is available.
+type cStorage struct{}
+
+func (storage *cStorage) initS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion string) error {
+	return nil
+}
+
+func (storage *cStorage) initFile(filepath string) error {
+	return nil
+}
+
+func (storage *cStorage) initBytes(data []byte) error {
+	return nil
+}
+
+func (storage *cStorage) initLazy(load func() ([]byte, error)) {}
+
+func (storage *cStorage) close() {}
+
+func (storage *cStorage) finalize() {}
+
+// The client-cpp backend is only available when built with cgo
+const cppBackendAvailable = false
+
+func newCPPClient(cfg *clientConfig) (TDFClient, error) {
+	return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: errors.New("the client-cpp backend requires building with cgo")}
+}
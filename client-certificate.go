@@ -0,0 +1,105 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Authenticate with an X.509 client certificate over mutual TLS, for IdPs (such as
+// Keycloak with its X509 client authenticator) that identify clients by certificate
+// rather than by secret. The certificate is presented to both the IdP and KAS.
+// certFile and keyFile are PEM files; caFile is an optional PEM bundle of the CAs
+// that issue the IdP and KAS server certificates, defaulting to the system roots.
+func WithClientCertificate(orgName, clientId, certFile, keyFile, caFile string) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthClientCertificate
+		cfg.orgName, cfg.clientId = orgName, clientId
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+		cfg.certFile, cfg.keyFile, cfg.caFile = certFile, keyFile, caFile
+		cfg.pkcs12File, cfg.pkcs12Password = "", ""
+	}
+}
+
+// Like WithClientCertificate, but with the certificate, its chain and its key read from
+// a password protected PKCS#12 (.p12 or .pfx) file. Only supported by the native backend.
+func WithClientCertificatePKCS12(orgName, clientId, pkcs12File, password, caFile string) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthClientCertificate
+		cfg.orgName, cfg.clientId = orgName, clientId
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+		cfg.certFile, cfg.keyFile, cfg.caFile = "", "", caFile
+		cfg.pkcs12File, cfg.pkcs12Password = pkcs12File, password
+	}
+}
+
+// Creates a new TDF client that will authenticate to the IdP and KAS with an X.509 client
+// certificate, read from PEM certFile and keyFile, over mutual TLS. caFile is optional.
+// Uses client-cpp when built with cgo, the native client otherwise.
+func NewTDFClientPKI(email, orgName, clientId, certFile, keyFile, caFile, oidcURL, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithUser(email), WithClientCertificate(orgName, clientId, certFile, keyFile, caFile),
+		WithOIDCURL(oidcURL), WithKASURL(kasURL), WithLogger(logger))
+}
+
+func (cfg *clientConfig) hasClientCertificate() bool {
+	return cfg.pkcs12File != "" || (cfg.certFile != "" && cfg.keyFile != "")
+}
+
+// loadClientCertificate reads the client certificate and key given to WithClientCertificate
+// or WithClientCertificatePKCS12.
+func (cfg *clientConfig) loadClientCertificate() (tls.Certificate, error) {
+	if cfg.pkcs12File == "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			return tls.Certificate{}, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: fmt.Errorf("loading client certificate: %w", err)}
+		}
+		return cert, nil
+	}
+
+	data, err := os.ReadFile(cfg.pkcs12File)
+	if err != nil {
+		return tls.Certificate{}, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: fmt.Errorf("loading client certificate: %w", err)}
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, cfg.pkcs12Password)
+	wipeBytes(data)
+	if err != nil {
+		return tls.Certificate{}, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: fmt.Errorf("decoding PKCS#12 client certificate: %w", err)}
+	}
+	cert := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	for _, ca := range chain {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// clientCertificateTLSConfig returns the TLS config the native backend authenticates with:
+// the config given to WithTLSConfig, if any, plus the client certificate and CA bundle.
+func (cfg *clientConfig) clientCertificateTLSConfig() (*tls.Config, error) {
+	cert, err := cfg.loadClientCertificate()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.tlsConfig != nil {
+		tlsConfig = cfg.tlsConfig.Clone()
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	if cfg.caFile != "" {
+		caPEM, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: fmt.Errorf("loading CA bundle: %w", err)}
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: errors.New("CA bundle holds no PEM certificates")}
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
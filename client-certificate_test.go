@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const testClientCN = "tdf-client"
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate from template, signed by parent, or self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	return newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: name}, IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+}
+
+func (c *testCert) writePEM(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}))
+	writeTestFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMTLSServer serves handler over TLS with serverCert, to clients presenting a
+// certificate issued by clientCA for testClientCN.
+func newMTLSServer(t *testing.T, handler http.Handler, serverCert, clientCA *testCert) *httptest.Server {
+	t.Helper()
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.cert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != testClientCN {
+			http.Error(w, "unknown client certificate", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientCertificate(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server CA"), newTestCA(t, "client CA")
+	serverCert := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, serverCA)
+	clientTemplate := &x509.Certificate{Subject: pkix.Name{CommonName: testClientCN}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	clientCert := newTestCert(t, clientTemplate, clientCA)
+
+	kas := newTestKAS(t)
+	idpHandler := newTestIdP(t).Config.Handler
+	mtlsKAS := &testKAS{Server: newMTLSServer(t, kas.Config.Handler, serverCert, clientCA), key: kas.key}
+	mtlsIdP := newMTLSServer(t, idpHandler, serverCert, clientCA)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCA.cert.Raw}))
+	certFile, keyFile := clientCert.writePEM(t, dir)
+	p12, err := pkcs12.Modern.Encode(clientCert.key, clientCert.cert, []*x509.Certificate{clientCA.cert}, "p12-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12File := filepath.Join(dir, "client.p12")
+	writeTestFile(t, p12File, p12)
+
+	otherDir := t.TempDir()
+	otherCertFile, otherKeyFile := newTestCert(t, clientTemplate, newTestCA(t, "other CA")).writePEM(t, otherDir)
+
+	tests := []struct {
+		name string
+		auth Option
+		// Whether the IdP and KAS accept the certificate
+		accepted bool
+	}{
+		{"PEM", WithClientCertificate("tdf", testClientCN, certFile, keyFile, caFile), true},
+		{"PKCS#12", WithClientCertificatePKCS12("tdf", testClientCN, p12File, "p12-password", caFile), true},
+		{"untrusted issuer", WithClientCertificate("tdf", testClientCN, otherCertFile, otherKeyFile, caFile), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tdfClient, err := NewTDFClient(WithBackend(BackendNative), tt.auth, WithOIDCURL(mtlsIdP.URL), WithKASURL(mtlsKAS.URL))
+			if err != nil {
+				t.Fatalf("NewTDFClient() error = %v", err)
+			}
+			defer tdfClient.Close()
+
+			err = encryptAndCheck(context.Background(), tdfClient, mtlsKAS, 0)
+			if tt.accepted && err != nil {
+				t.Errorf("encrypt and decrypt error = %v", err)
+			}
+			if !tt.accepted && err == nil {
+				t.Error("encrypt with an untrusted client certificate succeeded")
+			}
+		})
+	}
+
+	_, err = NewTDFClient(WithBackend(BackendNative), WithClientCertificatePKCS12("tdf", testClientCN, p12File, "wrong", caFile),
+		WithOIDCURL(mtlsIdP.URL), WithKASURL(mtlsKAS.URL))
+	if !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("NewTDFClient() with the wrong PKCS#12 password error = %v, want ErrInvalidParams", err)
+	}
+}
@@ -44,12 +44,32 @@ func encryptTDF(logger *zap.Logger, dataString, outPath string, dataAttr []strin
 	idpURL := os.Getenv("TDF_OIDC_URL")
 	externalToken := os.Getenv("TDF_EXTERNALTOKEN")
 
-	var tdfSDK client.TDFClient
+	opts := []client.Option{
+		client.WithUser(user),
+		client.WithOIDCURL(idpURL),
+		client.WithKASURL(kasURL),
+		client.WithLogger(logger),
+	}
+	switch {
+	case os.Getenv("TDF_DEVICE_LOGIN") != "":
+		//Log in as a user in the browser, remembering the login between runs
+		tokenCache, err := client.DefaultTokenCacheFile()
+		if err != nil {
+			log.Fatalf("Could not find a token cache location: %s", err)
+		}
+		opts = append(opts, client.WithDeviceCode(orgName, clientId, nil), client.WithTokenCache(tokenCache))
+	case externalToken != "":
+		opts = append(opts, client.WithTokenExchange(orgName, clientId, clientSecret, externalToken))
+	default:
+		opts = append(opts, client.WithClientCredentials(orgName, clientId, clientSecret))
+	}
+	if os.Getenv("TDF_NATIVE") != "" {
+		opts = append(opts, client.WithBackend(client.BackendNative))
+	}
 
-	if externalToken != "" {
-		tdfSDK = client.NewTDFClientOIDCTokenExchange(user, orgName, clientId, clientSecret, externalToken, idpURL, kasURL, logger)
-	} else {
-		tdfSDK = client.NewTDFClientOIDC(user, orgName, clientId, clientSecret, idpURL, kasURL, logger)
+	tdfSDK, err := client.NewTDFClient(opts...)
+	if err != nil {
+		log.Fatalf("Could not create TDF client: %s", err)
 	}
 
 	stringStore, _ := client.NewTDFStorageString(dataString)
@@ -79,5 +99,5 @@ func writeFile(outfilePath, tdfData string) {
 		log.Fatal(err)
 	}
 
-	fmt.Println("Wrote TDF to: %s", outfilePath)
+	fmt.Printf("Wrote TDF to: %s\n", outfilePath)
 }
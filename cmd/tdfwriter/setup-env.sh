@@ -8,5 +8,7 @@ export TDF_OIDC_URL="http://localhost:8080"
 export TDF_ORGNAME="tdf"
 export TDF_CLIENTSECRET="123-456"
 export TDF_EXTERNALTOKEN=""
+# Set to log in as a user with a device code instead; TDF_CLIENTID must then be a public client
+export TDF_DEVICE_LOGIN=""
 
 ./tdfwriter -a https://example.com/attr/COI/value/PRX
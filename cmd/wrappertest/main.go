@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -21,9 +23,112 @@ func main() {
 	//nolint:errcheck
 	defer logger.Sync()
 
-	//slammer(logger)
-	sequentialOIDC(logger)
+	slam := flag.Int("slam", 0, "Instead of the sequential run, do this many concurrent encrypt/decrypt round trips on one shared client, checking that no call sees another's attributes or metadata. Run with `go run -race` to also check for data races")
+	flag.Parse()
 
+	if *slam > 0 {
+		slammer(logger, *slam)
+	} else {
+		sequentialOIDC(logger)
+	}
+
+	//Only finds anything when run with OPENTDF_LEAK_DEBUG=1
+	if err := client.CheckCLeaks(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Hammers one TDFClient from many goroutines, each with its own data attributes and metadata
+func slammer(logger *zap.Logger, goroutines int) {
+	opts := []client.Option{
+		client.WithUser(os.Getenv("TDF_USER")),
+		client.WithOIDCURL(os.Getenv("TDF_OIDC_URL")),
+		client.WithKASURL(os.Getenv("TDF_KAS_URL")),
+		client.WithLogger(logger),
+	}
+	if externalToken := os.Getenv("TDF_EXTERNALTOKEN"); externalToken != "" {
+		opts = append(opts, client.WithTokenExchange(os.Getenv("TDF_ORGNAME"), os.Getenv("TDF_CLIENTID"), os.Getenv("TDF_CLIENTSECRET"), externalToken))
+	} else {
+		opts = append(opts, client.WithClientCredentials(os.Getenv("TDF_ORGNAME"), os.Getenv("TDF_CLIENTID"), os.Getenv("TDF_CLIENTSECRET")))
+	}
+	if os.Getenv("TDF_NATIVE") != "" {
+		opts = append(opts, client.WithBackend(client.BackendNative))
+	}
+	tdfSDK, err := client.NewTDFClient(opts...)
+	if err != nil {
+		log.Fatalf("Could not create TDF client: %s", err)
+	}
+	defer tdfSDK.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 1; i <= goroutines; i++ {
+		wg.Add(1)
+		go func(iter int) {
+			defer wg.Done()
+			if err := checkedRoundtrip(tdfSDK, iter); err != nil {
+				errs <- fmt.Errorf("round trip #%d: %w", iter, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		log.Println(err)
+		failed++
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d concurrent round trips failed", failed, goroutines)
+	}
+	fmt.Printf("All %d concurrent round trips succeeded\n", goroutines)
+}
+
+func checkedRoundtrip(tdfSDK client.TDFClient, iter int) error {
+	ctx := context.Background()
+	payload := fmt.Sprintf("payload #%d", iter)
+	metadata := fmt.Sprintf("<metadata-%d>", iter)
+	attr := fmt.Sprintf("https://example.com/attr/Slammer/value/%d", iter)
+
+	stringStore, err := client.NewTDFStorageString(payload)
+	if err != nil {
+		return err
+	}
+	defer stringStore.Close()
+	res, err := tdfSDK.EncryptWithOptions(ctx, stringStore, client.EncryptOptions{Metadata: metadata, DataAttributes: []string{attr}})
+	if err != nil {
+		return err
+	}
+
+	resStore, err := client.NewTDFStorageString(string(res))
+	if err != nil {
+		return err
+	}
+	defer resStore.Close()
+
+	policy, err := tdfSDK.GetPolicyFromTDF(resStore)
+	if err != nil {
+		return err
+	}
+	if attrs := policy.Body.DataAttributes; len(attrs) != 1 || attrs[0].Attribute != attr {
+		return fmt.Errorf("expected only attribute %s, got %v", attr, attrs)
+	}
+	gotMetadata, err := tdfSDK.GetEncryptedMetadata(resStore)
+	if err != nil {
+		return err
+	}
+	if gotMetadata != metadata {
+		return fmt.Errorf("expected metadata %s, got %s", metadata, gotMetadata)
+	}
+	decRes, err := tdfSDK.DecryptTDFContext(ctx, resStore)
+	if err != nil {
+		return err
+	}
+	if decRes != payload {
+		return fmt.Errorf("expected plaintext %s, got %s", payload, decRes)
+	}
+	return nil
 }
 
 func sequentialOIDC(logger *zap.Logger) {
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// Polling interval when the IdP doesn't give one, and the amount a slow_down adds
+	defaultDevicePollInterval = 5 * time.Second
+	// How long codes last when the IdP doesn't say, as in RFC 8628's example
+	defaultDeviceCodeExpiry = 30 * time.Minute
+)
+
+// DeviceAuthorization is what a user needs to approve a WithDeviceCode login.
+type DeviceAuthorization struct {
+	// Code the user enters at VerificationURI
+	UserCode        string
+	VerificationURI string
+	// VerificationURI with the code filled in, if the IdP gives one
+	VerificationURIComplete string
+	// When the code stops being accepted
+	Expires time.Time
+}
+
+// Authenticate as a user with the OAuth 2.0 device authorization grant (RFC 8628), for
+// command line tools that shouldn't hold a client secret. clientId must be a public client
+// with the grant enabled. When the client first needs an access token, it gets a code from
+// the IdP and passes it to prompt, which should show it to the user without blocking, then
+// polls the IdP until the user has approved the login in a browser. A nil prompt prints
+// the code to stderr. Use WithTokenCache to keep the login between runs.
+// Only supported by the native backend.
+func WithDeviceCode(orgName, clientId string, prompt func(DeviceAuthorization)) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthDeviceCode
+		cfg.orgName, cfg.clientId = orgName, clientId
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+		cfg.devicePrompt = prompt
+	}
+}
+
+func printDeviceAuthorization(auth DeviceAuthorization) {
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "To sign in, open %s\nor open %s and enter the code %s\n", auth.VerificationURIComplete, auth.VerificationURI, auth.UserCode)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "To sign in, open %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+}
+
+// interactiveLogin is a grant that needs the user, used by oidcCredentials in place
+// of posting its form.
+type interactiveLogin interface {
+	login(ctx context.Context, creds *oidcCredentials, clientPublicKeyPEM string) error
+}
+
+type deviceCodeLogin struct {
+	deviceURL string
+	prompt    func(DeviceAuthorization)
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+func newOIDCDeviceCode(oidcURL, orgName, clientId string, prompt func(DeviceAuthorization), httpClient *http.Client) *oidcCredentials {
+	if prompt == nil {
+		prompt = printDeviceAuthorization
+	}
+	return &oidcCredentials{
+		tokenURL:   oidcTokenURL(oidcURL, orgName),
+		form:       url.Values{"client_id": {clientId}},
+		httpClient: httpClient,
+		lock:       make(chan struct{}, 1),
+		interactive: &deviceCodeLogin{
+			deviceURL: oidcRealmURL(oidcURL, orgName) + "/protocol/openid-connect/auth/device",
+			prompt:    prompt,
+		},
+	}
+}
+
+func (device *deviceCodeLogin) login(ctx context.Context, creds *oidcCredentials, clientPublicKeyPEM string) error {
+	auth, err := device.authorize(ctx, creds)
+	if err != nil {
+		return err
+	}
+	lifetime := time.Duration(auth.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultDeviceCodeExpiry
+	}
+	expires := time.Now().Add(lifetime)
+	device.prompt(DeviceAuthorization{
+		UserCode:                auth.UserCode,
+		VerificationURI:         auth.VerificationURI,
+		VerificationURIComplete: auth.VerificationURIComplete,
+		Expires:                 expires,
+	})
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+	form := url.Values{
+		"grant_type":  {grantTypeDeviceCode},
+		"device_code": {auth.DeviceCode},
+		"client_id":   creds.form["client_id"],
+	}
+	for {
+		if time.Now().After(expires) {
+			return &TDFError{Kind: ErrAccessDenied, Op: "OIDC device login", Err: errors.New("the code expired before the login was approved")}
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err := creds.fetch(ctx, form, clientPublicKeyPEM)
+		var oidcErr *oidcError
+		if !errors.As(err, &oidcErr) {
+			return err
+		}
+		switch oidcErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += defaultDevicePollInterval
+		case "access_denied", "expired_token":
+			err.(*TDFError).Kind = ErrAccessDenied
+			return err
+		default:
+			return err
+		}
+	}
+}
+
+// authorize asks the IdP for a device code and the user code that goes with it.
+func (device *deviceCodeLogin) authorize(ctx context.Context, creds *oidcCredentials) (*deviceAuthorizationResponse, error) {
+	form := url.Values{"client_id": creds.form["client_id"]}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, device.deviceURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := creds.httpClient.Do(req)
+	if err != nil {
+		return nil, newNetworkError("OIDC device authorization", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, newNetworkError("OIDC device authorization", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		tdfErr := newHTTPStatusError("OIDC device authorization", resp.StatusCode).(*TDFError)
+		var errResp oidcErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			tdfErr.Err = &oidcError{Code: errResp.Error, Description: errResp.ErrorDescription}
+		}
+		return nil, tdfErr
+	}
+
+	var auth deviceAuthorizationResponse
+	if err := json.Unmarshal(body, &auth); err != nil {
+		return nil, &TDFError{Kind: ErrFailure, Op: "OIDC device authorization", Err: err}
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" || auth.VerificationURI == "" {
+		return nil, &TDFError{Kind: ErrFailure, Op: "OIDC device authorization", Err: errors.New("response is missing the device code, user code or verification URI")}
+	}
+	return &auth, nil
+}
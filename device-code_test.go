@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDeviceIdP is a stand-in IdP for the device authorization grant that leaves
+// out expires_in, and answers the first token poll with authorization_pending.
+func newTestDeviceIdP(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	polls := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/realms/tdf/protocol/openid-connect/auth/device":
+			json.NewEncoder(w).Encode(deviceAuthorizationResponse{ //nolint:errcheck
+				DeviceCode: "device-code", UserCode: "ABCD-EFGH", VerificationURI: "https://idp.example.com/device", Interval: 1})
+		case "/auth/realms/tdf/protocol/openid-connect/token":
+			if r.FormValue("grant_type") != grantTypeDeviceCode || r.FormValue("device_code") != "device-code" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			polls++
+			first := polls == 1
+			mu.Unlock()
+			if first {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(oidcErrorResponse{Error: "authorization_pending"}) //nolint:errcheck
+				return
+			}
+			json.NewEncoder(w).Encode(oidcTokenResponse{AccessToken: testAccessToken, TokenType: "Bearer", ExpiresIn: 300}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestDeviceCodeWithoutExpiry(t *testing.T) {
+	idp, kas := newTestDeviceIdP(t), newTestKAS(t)
+	var prompted []DeviceAuthorization
+	prompt := func(auth DeviceAuthorization) {
+		prompted = append(prompted, auth)
+	}
+	tdfClient, err := NewTDFClient(WithBackend(BackendNative), WithDeviceCode("tdf", "tdf-cli", prompt),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	defer tdfClient.Close()
+
+	if err := encryptAndCheck(context.Background(), tdfClient, kas, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(prompted) != 1 {
+		t.Fatalf("prompted %d times, want once", len(prompted))
+	}
+	if lifetime := time.Until(prompted[0].Expires); lifetime < defaultDeviceCodeExpiry-time.Minute {
+		t.Errorf("code expires in %v, want the default of %v", lifetime, defaultDeviceCodeExpiry)
+	}
+}
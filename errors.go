@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+// Sentinel errors describing why a TDF operation failed. Errors returned by a
+// TDFClient can be matched against these with errors.Is, and inspected for
+// the failing operation and status code with errors.As and *TDFError.
+var (
+	ErrInvalidParams = errors.New("bad param")
+	ErrNetwork       = errors.New("network error")
+	// client-cpp reports IdP and KAS denials as ErrFailure, so with that backend this
+	// only comes from the Go storage backends
+	ErrAccessDenied = errors.New("access denied")
+	// Same value as tdf3.ErrIntegrity, so payload integrity failures match either
+	ErrIntegrity = tdf3.ErrIntegrity
+	ErrPolicy    = errors.New("invalid policy")
+	// The external access token given for token exchange has expired, and the client
+	// has no refresh token to carry on with. Replace it with SetExternalAccessToken.
+	ErrTokenExpired = errors.New("access token expired")
+	// Returned when the client-cpp SDK reports a failure without saying why
+	ErrFailure = errors.New("TDF operation failed")
+)
+
+// TDFError is the error type returned for failed TDF operations.
+type TDFError struct {
+	// One of the Err... sentinels above
+	Kind error
+	// The C SDK function, or the native client operation, that failed
+	Op string
+	// The TDF_STATUS returned by the C SDK, or the HTTP status code returned to the
+	// native client. Zero if there was none.
+	Status int
+	// Underlying cause, may be nil
+	Err error
+}
+
+func (e *TDFError) Error() string {
+	msg := fmt.Sprintf("%s calling %s", e.Kind, e.Op)
+	if e.Status != 0 {
+		msg += fmt.Sprintf(", got code %d", e.Status)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the Kind of this error, so errors.Is(err, ErrNetwork) works.
+func (e *TDFError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *TDFError) Unwrap() error {
+	return e.Err
+}
+
+var errClientClosed = &TDFError{Kind: ErrInvalidParams, Op: "TDFClient", Err: errors.New("client is closed")}
+
+func newNetworkError(op string, err error) error {
+	return &TDFError{Kind: ErrNetwork, Op: op, Err: err}
+}
+
+// newHTTPStatusError classifies a non-200 response from an OIDC or KAS endpoint.
+func newHTTPStatusError(op string, status int) error {
+	kind := ErrFailure
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		kind = ErrAccessDenied
+	case status >= 400 && status < 500:
+		kind = ErrInvalidParams
+	case status >= 500:
+		kind = ErrNetwork
+	}
+	return &TDFError{Kind: kind, Op: op, Status: status}
+}
+
+// translateTDF3Error classifies errors coming out of the tdf3 package.
+func translateTDF3Error(op string, err error) error {
+	if errors.Is(err, tdf3.ErrNotTDF) {
+		return &TDFError{Kind: ErrInvalidParams, Op: op, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	// Set by convention to the host of a GCS emulator such as fake-gcs-server
+	gcsEmulatorHostEnv = "STORAGE_EMULATOR_HOST"
+	// Size of each chunk of a resumable upload; GCS needs a multiple of 256 KiB.
+	// Objects no bigger than one chunk are uploaded with a single request.
+	gcsChunkSize = 8 << 20
+)
+
+// NewGCSBackend returns a StorageBackend for a Google Cloud Storage object, named as
+// gs://bucket/object and accessed through the GCS JSON API with an OAuth2 access token.
+// If STORAGE_EMULATOR_HOST is set, as for Google's own client libraries, requests go
+// to that emulator instead, and accessToken may be empty.
+func NewGCSBackend(gsURL, accessToken string) (StorageBackend, error) {
+	u, err := url.Parse(gsURL)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewGCSBackend", Err: err}
+	}
+	object := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "gs" || u.Host == "" || object == "" {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewGCSBackend", Err: fmt.Errorf("%q is not a gs://bucket/object URL", gsURL)}
+	}
+
+	endpoint := gcsEndpoint
+	if host := os.Getenv(gcsEmulatorHostEnv); host != "" {
+		endpoint = host
+		if !strings.Contains(host, "://") {
+			endpoint = "http://" + host
+		}
+	}
+	return &gcsBackend{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		bucket:      u.Host,
+		object:      object,
+		accessToken: accessToken,
+		httpClient: &http.Client{
+			Transport: storageTransport,
+			//Resumable uploads answer 308 to mean "send the next chunk", not to redirect
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+type gcsBackend struct {
+	endpoint    string
+	bucket      string
+	object      string
+	accessToken string
+	httpClient  *http.Client
+}
+
+func (gcs *gcsBackend) Descriptor() string {
+	return "gs://" + gcs.bucket + "/" + gcs.object
+}
+
+func (gcs *gcsBackend) objectURL(query string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s?%s", gcs.endpoint, url.PathEscape(gcs.bucket), url.PathEscape(gcs.object), query)
+}
+
+func (gcs *gcsBackend) do(ctx context.Context, method, target string, body []byte, header http.Header, op string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: op, Err: err}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if gcs.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+gcs.accessToken)
+	}
+	return doStorageRequest(gcs.httpClient, req, op)
+}
+
+func (gcs *gcsBackend) Size(ctx context.Context) (int64, error) {
+	resp, err := gcs.do(ctx, http.MethodGet, gcs.objectURL("fields=size"), nil, nil, "GCS get object")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var object struct {
+		Size int64 `json:"size,string"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&object); err != nil {
+		return 0, newNetworkError("GCS get object", err)
+	}
+	return object.Size, nil
+}
+
+func (gcs *gcsBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := gcs.do(ctx, http.MethodGet, gcs.objectURL("alt=media"), nil, nil, "GCS download")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (gcs *gcsBackend) ReadRange(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {httpRange(offset, length)}}
+	resp, err := gcs.do(ctx, http.MethodGet, gcs.objectURL("alt=media"), nil, header, "GCS download")
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset)
+}
+
+func (gcs *gcsBackend) Create(ctx context.Context) (StorageWriter, error) {
+	return &chunkedWriter{uploader: &gcsUpload{ctx: ctx, gcs: gcs}, partSize: gcsChunkSize}, nil
+}
+
+// gcsUpload only starts a resumable upload once the first chunk fills up, so
+// small objects need a single request.
+type gcsUpload struct {
+	ctx        context.Context
+	gcs        *gcsBackend
+	sessionURL string
+	uploaded   int64
+}
+
+func (up *gcsUpload) uploadURL(uploadType string) string {
+	return fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=%s&name=%s",
+		up.gcs.endpoint, url.PathEscape(up.gcs.bucket), uploadType, url.QueryEscape(up.gcs.object))
+}
+
+func (up *gcsUpload) uploadPart(part []byte) error {
+	if up.sessionURL == "" {
+		resp, err := up.gcs.do(up.ctx, http.MethodPost, up.uploadURL("resumable"), nil, nil, "GCS start upload")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		up.sessionURL = resp.Header.Get("Location")
+		if up.sessionURL == "" {
+			return &TDFError{Kind: ErrFailure, Op: "GCS start upload", Err: errors.New("no upload session URL")}
+		}
+	}
+	return up.putChunk(part, fmt.Sprintf("bytes %d-%d/*", up.uploaded, up.uploaded+int64(len(part))-1))
+}
+
+func (up *gcsUpload) finish(last []byte, parts int) error {
+	if parts == 0 {
+		resp, err := up.gcs.do(up.ctx, http.MethodPost, up.uploadURL("media"), last, nil, "GCS upload")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	total := up.uploaded + int64(len(last))
+	contentRange := fmt.Sprintf("bytes */%d", total)
+	if len(last) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", up.uploaded, total-1, total)
+	}
+	return up.putChunk(last, contentRange)
+}
+
+func (up *gcsUpload) putChunk(chunk []byte, contentRange string) error {
+	req, err := http.NewRequestWithContext(up.ctx, http.MethodPut, up.sessionURL, bytes.NewReader(chunk))
+	if err != nil {
+		return &TDFError{Kind: ErrInvalidParams, Op: "GCS upload", Err: err}
+	}
+	req.Header.Set("Content-Range", contentRange)
+	//The session URL is the credential for the upload, so no Authorization header is needed
+	resp, err := up.gcs.httpClient.Do(req)
+	if err != nil {
+		return newNetworkError("GCS upload", err)
+	}
+	defer resp.Body.Close()
+	//308 acknowledges a chunk of an upload that isn't finished yet
+	if resp.StatusCode != http.StatusPermanentRedirect && resp.StatusCode/100 != 2 {
+		return newHTTPStatusError("GCS upload", resp.StatusCode)
+	}
+	up.uploaded += int64(len(chunk))
+	return nil
+}
+
+func (up *gcsUpload) abort() {
+	if up.sessionURL == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, up.sessionURL, nil)
+	if err != nil {
+		return
+	}
+	//Best effort; GCS answers a cancelled upload with 499
+	if resp, err := up.gcs.httpClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	up.sessionURL = ""
+}
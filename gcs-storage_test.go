@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testGCS is a stand-in for the GCS JSON API holding the objects of one bucket. It
+// supports simple and resumable uploads, checking that chunks arrive in order.
+type testGCS struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	sessions map[string]*gcsTestSession
+	chunks   int
+}
+
+type gcsTestSession struct {
+	object string
+	data   []byte
+}
+
+var gcsContentRange = regexp.MustCompile(`^bytes (\*|(\d+)-(\d+))/(\*|\d+)$`)
+
+func newTestGCS(t *testing.T) *testGCS {
+	t.Helper()
+	gcs := &testGCS{t: t, objects: make(map[string][]byte), sessions: make(map[string]*gcsTestSession)}
+	gcs.Server = httptest.NewServer(http.HandlerFunc(gcs.serve))
+	t.Cleanup(gcs.Close)
+	t.Setenv(gcsEmulatorHostEnv, gcs.URL)
+	return gcs
+}
+
+func (gcs *testGCS) serve(w http.ResponseWriter, r *http.Request) {
+	gcs.mu.Lock()
+	defer gcs.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		object, ok := gcs.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if query.Get("alt") == "media" {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(object))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"size": strconv.Itoa(len(object))}) //nolint:errcheck
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		body, _ := io.ReadAll(r.Body)
+		switch query.Get("uploadType") {
+		case "media":
+			gcs.objects[query.Get("name")] = body
+		case "resumable":
+			id := fmt.Sprintf("/session/%d", len(gcs.sessions)+1)
+			gcs.sessions[id] = &gcsTestSession{object: query.Get("name")}
+			w.Header().Set("Location", gcs.URL+id)
+		default:
+			http.Error(w, "bad uploadType", http.StatusBadRequest)
+		}
+	case r.Method == http.MethodPut && gcs.sessions[r.URL.Path] != nil:
+		gcs.putChunk(w, r, gcs.sessions[r.URL.Path])
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (gcs *testGCS) putChunk(w http.ResponseWriter, r *http.Request, session *gcsTestSession) {
+	body, _ := io.ReadAll(r.Body)
+	m := gcsContentRange.FindStringSubmatch(r.Header.Get("Content-Range"))
+	if m == nil {
+		http.Error(w, "bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	if m[1] != "*" {
+		first, _ := strconv.Atoi(m[2])
+		last, _ := strconv.Atoi(m[3])
+		if first != len(session.data) || last-first+1 != len(body) {
+			http.Error(w, "chunk out of order", http.StatusBadRequest)
+			return
+		}
+		if m[4] == "*" && len(body)%(256<<10) != 0 {
+			http.Error(w, "chunk is not a multiple of 256 KiB", http.StatusBadRequest)
+			return
+		}
+		session.data = append(session.data, body...)
+		gcs.chunks++
+	}
+	if m[4] == "*" {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	if total, _ := strconv.Atoi(m[4]); total != len(session.data) {
+		http.Error(w, "upload is missing data", http.StatusBadRequest)
+		return
+	}
+	gcs.objects[session.object] = session.data
+}
+
+func TestGCSBackend(t *testing.T) {
+	idp, kas, gcs := newTestIdP(t), newTestKAS(t), newTestGCS(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"simple upload", 1000, 0},
+		{"resumable upload", gcsChunkSize + gcsChunkSize/2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := strings.ReplaceAll(tt.name, " ", "-") + ".tdf"
+			backend, err := NewGCSBackend("gs://bucket/"+name, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			gcs.mu.Lock()
+			gcs.chunks = 0
+			gcs.mu.Unlock()
+
+			checkBackendRoundTrip(t, tdfClient, backend, bytes.Repeat([]byte("g"), tt.size))
+
+			gcs.mu.Lock()
+			defer gcs.mu.Unlock()
+			if gcs.chunks != tt.chunks {
+				t.Errorf("uploaded %d resumable chunks, want %d", gcs.chunks, tt.chunks)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@ module github.com/opentdf/client-go
 
 go 1.19
 
-require go.uber.org/zap v1.23.0
+require (
+	go.uber.org/zap v1.23.0
+	golang.org/x/oauth2 v0.22.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
 
 require (
 	go.uber.org/atomic v1.10.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
 )
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Creates a new TDF storage object for a TDF served over HTTP(S), such as a static
+// file or a presigned object URL. headers are sent with every request, e.g. for
+// an Authorization header. The native client uses Range requests to read only the
+// zip directory, the manifest and the payload segments an operation needs; the
+// client-cpp backend downloads the whole TDF first. The server should support
+// Range requests - if it doesn't, every read downloads the file up to that point.
+func NewTDFStorageHTTP(tdfURL string, headers http.Header) (*TDFStorage, error) {
+	u, err := url.Parse(tdfURL)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFStorageHTTP", Err: err}
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFStorageHTTP", Err: fmt.Errorf("%q is not an HTTP URL", u.Redacted())}
+	}
+	return NewTDFStorageBackend(&httpBackend{url: u, headers: headers.Clone(), httpClient: &http.Client{Transport: storageTransport}})
+}
+
+// httpBackend is a read-only StorageBackend over an HTTP resource.
+type httpBackend struct {
+	url        *url.URL
+	headers    http.Header
+	httpClient *http.Client
+
+	// Strong ETag seen when sizing the resource, sent as If-Match so reads fail
+	// rather than mixing two versions of a TDF that changes on the server
+	mu   sync.Mutex
+	etag string
+}
+
+// Descriptor leaves out the query, which may hold a presigned URL's signature.
+func (backend *httpBackend) Descriptor() string {
+	u := *backend.url
+	u.RawQuery = ""
+	return u.Redacted()
+}
+
+func (backend *httpBackend) get(ctx context.Context, rangeHeader string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, backend.url.String(), nil)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "HTTP GET", Err: err}
+	}
+	for name, values := range backend.headers {
+		req.Header[name] = values
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	backend.mu.Lock()
+	if backend.etag != "" {
+		req.Header.Set("If-Match", backend.etag)
+	}
+	backend.mu.Unlock()
+	resp, err := doStorageRequest(backend.httpClient, req, "HTTP GET")
+	var tdfErr *TDFError
+	if errors.As(err, &tdfErr) && tdfErr.Status == http.StatusPreconditionFailed {
+		tdfErr.Kind, tdfErr.Err = ErrFailure, errors.New("TDF changed on the server while it was being read")
+	}
+	return resp, err
+}
+
+// Size asks for the first byte, rather than sending a HEAD, since presigned
+// URLs are often only valid for GET.
+func (backend *httpBackend) Size(ctx context.Context) (int64, error) {
+	resp, err := backend.get(ctx, "bytes=0-0")
+	if err != nil {
+		//An empty resource has no first byte
+		var tdfErr *TDFError
+		if errors.As(err, &tdfErr) && tdfErr.Status == http.StatusRequestedRangeNotSatisfiable {
+			return 0, nil
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		backend.mu.Lock()
+		backend.etag = etag
+		backend.mu.Unlock()
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.ContentLength < 0 {
+			return 0, &TDFError{Kind: ErrFailure, Op: "HTTP GET", Err: errors.New("server sent neither Content-Range nor Content-Length")}
+		}
+		return resp.ContentLength, nil
+	}
+	//Content-Range: bytes 0-0/1234
+	contentRange := resp.Header.Get("Content-Range")
+	slash := strings.LastIndexByte(contentRange, '/')
+	size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+	if slash < 0 || err != nil {
+		return 0, &TDFError{Kind: ErrFailure, Op: "HTTP GET", Err: fmt.Errorf("can't find the size in Content-Range %q", contentRange)}
+	}
+	return size, nil
+}
+
+func (backend *httpBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := backend.get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (backend *httpBackend) ReadRange(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	resp, err := backend.get(ctx, httpRange(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return rangeBody(resp, offset)
+}
+
+func (backend *httpBackend) Create(ctx context.Context) (StorageWriter, error) {
+	return nil, &TDFError{Kind: ErrInvalidParams, Op: "HTTP storage", Err: errors.New("HTTP storage is read-only")}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHTTPFile is a stand-in static file server for one TDF, which needs the test
+// access token and counts the bytes it sends.
+type testHTTPFile struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	data []byte
+	etag string
+	sent int64
+}
+
+func newTestHTTPFile(t *testing.T, data []byte) *testHTTPFile {
+	t.Helper()
+	file := &testHTTPFile{data: data, etag: `"v1"`}
+	file.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		file.mu.Lock()
+		data, etag := file.data, file.etag
+		file.mu.Unlock()
+		w.Header().Set("ETag", etag)
+		counter := &countingWriter{ResponseWriter: w}
+		http.ServeContent(counter, r, "", time.Time{}, bytes.NewReader(data))
+		file.mu.Lock()
+		file.sent += counter.n
+		file.mu.Unlock()
+	}))
+	t.Cleanup(file.Close)
+	return file
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func TestHTTPStorage(t *testing.T) {
+	idp, kas := newTestIdP(t), newTestKAS(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+	ctx := context.Background()
+
+	plaintext := bytes.Repeat([]byte("h"), 10<<20)
+	storage, err := NewTDFStorageBytes(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	tdf, err := tdfClient.EncryptWithOptions(ctx, storage, EncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := newTestHTTPFile(t, tdf)
+	header := http.Header{"Authorization": {"Bearer " + testAccessToken}}
+
+	t.Run("range read", func(t *testing.T) {
+		tdfStorage, err := NewTDFStorageHTTP(file.URL+"/file.tdf?sig=secret", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tdfStorage.Close()
+		if desc, _ := tdfClient.GetStorageTypeDescriptor(tdfStorage); desc != file.URL+"/file.tdf" {
+			t.Errorf("descriptor = %s, want the URL without its query", desc)
+		}
+		file.mu.Lock()
+		file.sent = 0
+		file.mu.Unlock()
+
+		part, err := tdfClient.DecryptRange(ctx, tdfStorage, 5<<20, 100)
+		if err != nil {
+			t.Fatalf("DecryptRange() error = %v", err)
+		}
+		if !bytes.Equal(part, plaintext[5<<20:5<<20+100]) {
+			t.Error("decrypted range does not match the plaintext")
+		}
+		file.mu.Lock()
+		defer file.mu.Unlock()
+		if file.sent > int64(len(tdf))/4 {
+			t.Errorf("server sent %d of %d bytes for a 100 byte range", file.sent, len(tdf))
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		tdfStorage, err := NewTDFStorageHTTP(file.URL+"/file.tdf", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tdfStorage.Close()
+		if _, err := tdfClient.DecryptBytes(ctx, tdfStorage); !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("DecryptBytes() error = %v, want ErrAccessDenied", err)
+		}
+	})
+
+	t.Run("changed on the server", func(t *testing.T) {
+		tdfStorage, err := NewTDFStorageHTTP(file.URL+"/file.tdf", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tdfStorage.Close()
+		reader, err := tdfClient.NewReaderAt(ctx, tdfStorage, 1)
+		if err != nil {
+			t.Fatalf("NewReaderAt() error = %v", err)
+		}
+		defer reader.Close()
+
+		file.mu.Lock()
+		file.etag = `"v2"`
+		file.mu.Unlock()
+		defer func() {
+			file.mu.Lock()
+			file.etag = `"v1"`
+			file.mu.Unlock()
+		}()
+		if _, err := reader.ReadAt(make([]byte, 100), 5<<20); !errors.Is(err, ErrFailure) {
+			t.Errorf("ReadAt() of a changed TDF error = %v, want ErrFailure", err)
+		}
+	})
+
+	t.Run("hung server", func(t *testing.T) {
+		release := make(chan struct{})
+		hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer hung.Close()
+		defer close(release)
+
+		tdfStorage, err := NewTDFStorageHTTP(hung.URL+"/file.tdf", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tdfStorage.Close()
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if _, err := tdfClient.DecryptBytes(ctx, tdfStorage); err == nil {
+			t.Error("DecryptBytes() from a hung server succeeded")
+		}
+	})
+}
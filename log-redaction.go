@@ -0,0 +1,124 @@
+package client
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log field keys for sensitive values. The client logs such values only as fields
+// with these keys, never inside the message, so a LogPolicy can redact them; use
+// the same keys to have a redacting logger cover your own log calls.
+const (
+	// Plaintext, TDF contents and encrypted metadata
+	LogKeyPayload = "payload"
+	// Access tokens and other bearer credentials
+	LogKeyToken = "token"
+	// Client secrets and private keys
+	LogKeySecret = "secret"
+	// TDF policies, which name data attributes and dissemination lists
+	LogKeyPolicy = "policy"
+)
+
+// Logged in place of a redacted value
+const redactedLogValue = "[REDACTED]"
+
+// LogPolicy says which kinds of sensitive value may appear in log output. The zero
+// value, which is the default, redacts all of them.
+type LogPolicy struct {
+	AllowPayloads bool
+	AllowTokens   bool
+	AllowSecrets  bool
+	AllowPolicies bool
+}
+
+func (policy LogPolicy) allows(key string) bool {
+	switch key {
+	case LogKeyPayload:
+		return policy.AllowPayloads
+	case LogKeyToken:
+		return policy.AllowTokens
+	case LogKeySecret:
+		return policy.AllowSecrets
+	case LogKeyPolicy:
+		return policy.AllowPolicies
+	default:
+		return true
+	}
+}
+
+// allowsAll reports whether nothing is redacted, which is required for logging that
+// bypasses redaction, such as client-cpp's own console logging.
+func (policy LogPolicy) allowsAll() bool {
+	return policy.AllowPayloads && policy.AllowTokens && policy.AllowSecrets && policy.AllowPolicies
+}
+
+// RedactLogger returns a logger that writes to the same place as logger, replacing the
+// values of LogKey... fields that policy doesn't allow. Every TDFClient wraps its
+// logger this way, with the policy given by WithLogPolicy.
+func RedactLogger(logger *zap.Logger, policy LogPolicy) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core, policy: policy}
+	}))
+}
+
+type redactingCore struct {
+	zapcore.Core
+	policy LogPolicy
+}
+
+func (core *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: core.Core.With(core.redact(fields)), policy: core.policy}
+}
+
+// Check leaves the decision to the wrapped core, so its sampling and the level of
+// each core of a tee still apply, then adds a core that hands the cores it chose
+// the redacted fields.
+func (core *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	downstream := core.Core.Check(entry, nil)
+	if downstream == nil {
+		return checked
+	}
+	//The logger only sets the error output of the entry it checked, which is ours
+	downstream.ErrorOutput = redactedWriteErrors
+	return checked.AddCore(entry, &redactedWrite{redactingCore: core, downstream: downstream})
+}
+
+func (core *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return core.Core.Write(entry, core.redact(fields))
+}
+
+// Where errors writing to the wrapped core go, as for a zap.Logger by default
+var redactedWriteErrors = zapcore.Lock(os.Stderr)
+
+// redactedWrite writes an entry checked by a redactingCore to the cores the
+// wrapped core chose for it.
+type redactedWrite struct {
+	*redactingCore
+	downstream *zapcore.CheckedEntry
+}
+
+func (w *redactedWrite) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	w.downstream.Write(w.redact(fields)...)
+	return nil
+}
+
+// redact returns fields with disallowed values replaced, copying fields only if
+// there is something to redact.
+func (core *redactingCore) redact(fields []zapcore.Field) []zapcore.Field {
+	var redacted []zapcore.Field
+	for i, field := range fields {
+		if core.policy.allows(field.Key) {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]zapcore.Field(nil), fields...)
+		}
+		redacted[i] = zap.String(field.Key, redactedLogValue)
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
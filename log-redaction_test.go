@@ -0,0 +1,56 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func checkRedacted(t *testing.T, logs *observer.ObservedLogs, want int) {
+	t.Helper()
+	if logs.Len() != want {
+		t.Fatalf("wrote %d entries, want %d", logs.Len(), want)
+	}
+	for _, entry := range logs.All() {
+		if token := entry.ContextMap()[LogKeyToken]; token != redactedLogValue {
+			t.Errorf("token field = %v, want %s", token, redactedLogValue)
+		}
+	}
+}
+
+func TestRedactLoggerKeepsSampling(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := RedactLogger(zap.New(zapcore.NewSamplerWithOptions(core, time.Minute, 2, 0)), LogPolicy{})
+	for i := 0; i < 5; i++ {
+		logger.Info("same message", zap.String(LogKeyToken, "secret-token"))
+	}
+	checkRedacted(t, logs, 2)
+}
+
+func TestRedactLoggerKeepsTeeLevels(t *testing.T) {
+	infoCore, infoLogs := observer.New(zap.InfoLevel)
+	debugCore, debugLogs := observer.New(zap.DebugLevel)
+	logger := RedactLogger(zap.New(zapcore.NewTee(infoCore, debugCore)), LogPolicy{})
+
+	logger.Debug("debug message", zap.String(LogKeyToken, "secret-token"))
+	checkRedacted(t, infoLogs, 0)
+	checkRedacted(t, debugLogs, 1)
+
+	logger.With(zap.String(LogKeyToken, "secret-token")).Info("info message")
+	checkRedacted(t, infoLogs, 1)
+	checkRedacted(t, debugLogs, 2)
+}
+
+func TestRedactLoggerPolicy(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := RedactLogger(zap.New(core), LogPolicy{AllowTokens: true})
+	logger.Info("message", zap.String(LogKeyToken, "visible-token"), zap.String(LogKeySecret, "client-secret"))
+
+	fields := logs.All()[0].ContextMap()
+	if fields[LogKeyToken] != "visible-token" || fields[LogKeySecret] != redactedLogValue {
+		t.Errorf("fields = %v, want the token shown and the secret redacted", fields)
+	}
+}
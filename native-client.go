@@ -0,0 +1,439 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/opentdf/client-go/tdf3"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHTTPTimeout = 60 * time.Second
+	clientKeyBits      = 2048
+)
+
+// tdfNative is a pure-Go TDFClient. It speaks the same OIDC and KAS protocols as
+// client-cpp and reads and writes TDF3 containers with the tdf3 package, so it
+// needs neither cgo nor the client-cpp library.
+type tdfNative struct {
+	kasURL             string
+	httpClient         *http.Client
+	creds              accessTokenSource
+	clientKey          *rsa.PrivateKey
+	clientPublicKeyPEM string
+	logger             *zap.SugaredLogger
+
+	kasKeys *kasKeyCache
+}
+
+// KAS public keys by KAS URL
+type kasKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Creates a new pure-Go TDF client that will use OIDC client secret credentials to authenticate.
+func NewNativeTDFClientOIDC(email, orgName, clientId, clientSecret, oidcURL, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithBackend(BackendNative), WithUser(email), WithClientCredentials(orgName, clientId, clientSecret),
+		WithOIDCURL(oidcURL), WithKASURL(kasURL), WithLogger(logger))
+}
+
+// Creates a new pure-Go TDF client that will use OIDC token exchange credentials to authenticate.
+func NewNativeTDFClientOIDCTokenExchange(email, orgName, clientId, clientSecret, externalAccessToken, oidcURL, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithBackend(BackendNative), WithUser(email), WithTokenExchange(orgName, clientId, clientSecret, externalAccessToken),
+		WithOIDCURL(oidcURL), WithKASURL(kasURL), WithLogger(logger))
+}
+
+func newNativeClient(cfg *clientConfig) (TDFClient, error) {
+	tdfsdk, err := newTDFNative(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return tdfsdk, nil
+}
+
+func newTDFNative(cfg *clientConfig) (*tdfNative, error) {
+	// Ephemeral key pair that KAS rewraps payload keys to
+	clientKey, err := rsa.GenerateKey(rand.Reader, clientKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tdfsdk := &tdfNative{
+		kasURL:             cfg.kasURL,
+		httpClient:         cfg.newHTTPClient(),
+		clientKey:          clientKey,
+		clientPublicKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		logger:             cfg.logger.Sugar(),
+		kasKeys:            &kasKeyCache{keys: make(map[string]*rsa.PublicKey)},
+	}
+	switch cfg.authMode {
+	case AuthTokenExchange:
+		tdfsdk.creds = newOIDCTokenExchange(cfg.oidcURL, cfg.orgName, cfg.clientId, cfg.clientSecret, cfg.externalAccessToken, tdfsdk.httpClient)
+	case AuthClientCertificate:
+		tdfsdk.creds = newOIDCClientCertificate(cfg.oidcURL, cfg.orgName, cfg.clientId, tdfsdk.httpClient)
+	case AuthTokenSource:
+		tdfsdk.creds = &tokenSourceCredentials{source: cfg.tokenSource, bound: cfg.boundTokenSource}
+	case AuthDeviceCode, AuthAuthorizationCode:
+		var creds *oidcCredentials
+		if cfg.authMode == AuthDeviceCode {
+			creds = newOIDCDeviceCode(cfg.oidcURL, cfg.orgName, cfg.clientId, cfg.devicePrompt, tdfsdk.httpClient)
+		} else {
+			creds = newOIDCAuthorizationCode(cfg.oidcURL, cfg.orgName, cfg.clientId, cfg.openBrowser, tdfsdk.httpClient)
+		}
+		if cfg.tokenCacheFile != "" {
+			creds.useCache(newTokenCache(cfg.tokenCacheFile, creds.tokenURL, cfg.clientId, tdfsdk.logger))
+		}
+		tdfsdk.creds = creds
+	default:
+		tdfsdk.creds = newOIDCClientCredentials(cfg.oidcURL, cfg.orgName, cfg.clientId, cfg.clientSecret, tdfsdk.httpClient)
+	}
+	return tdfsdk, nil
+}
+
+// sharedClone returns a client that shares this one's key pair, OIDC credentials,
+// HTTP client and KAS key cache, so an access token fetched by either is used by both.
+// Access tokens are bound to the client public key, so the key pair must be shared too.
+func (tdfsdk *tdfNative) sharedClone() *tdfNative {
+	clone := *tdfsdk
+	return &clone
+}
+
+// The native client holds no C memory, but Close() should still be called
+// for symmetry with the cgo-backed client.
+func (tdfsdk *tdfNative) Close() {
+	tdfsdk.httpClient.CloseIdleConnections()
+}
+
+func (tdfsdk *tdfNative) SetExternalAccessToken(externalAccessToken string) error {
+	creds, ok := tdfsdk.creds.(*oidcCredentials)
+	if !ok {
+		return errNotTokenExchange
+	}
+	return creds.setSubjectToken(externalAccessToken)
+}
+
+func (tdfsdk *tdfNative) EncryptToFile(data *TDFStorage, outFile, metadata string, dataAttribs []string) error {
+	return tdfsdk.EncryptToFileContext(context.Background(), data, outFile, metadata, dataAttribs)
+}
+
+func (tdfsdk *tdfNative) EncryptToFileContext(ctx context.Context, data *TDFStorage, outFile, metadata string, dataAttribs []string) error {
+	return tdfsdk.EncryptToFileWithOptions(ctx, data, outFile, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+func (tdfsdk *tdfNative) EncryptToFileWithOptions(ctx context.Context, data *TDFStorage, outFile string, opts EncryptOptions) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error creating output file! Error was %s", err)
+		return err
+	}
+
+	err = tdfsdk.encrypt(ctx, data, f, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		tdfsdk.logger.Errorf("Error encrypting file!")
+		os.Remove(outFile)
+		return err
+	}
+	return nil
+}
+
+func (tdfsdk *tdfNative) EncryptToString(data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error) {
+	return tdfsdk.EncryptToStringContext(context.Background(), data, metadata, dataAttribs)
+}
+
+func (tdfsdk *tdfNative) EncryptToStringContext(ctx context.Context, data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error) {
+	return tdfsdk.EncryptWithOptions(ctx, data, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+func (tdfsdk *tdfNative) EncryptWithOptions(ctx context.Context, data *TDFStorage, opts EncryptOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tdfsdk.encrypt(ctx, data, &buf, opts); err != nil {
+		tdfsdk.logger.Errorf("Error encrypting string! Error was %s", err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncryptStream reads plaintext from r until EOF and writes the TDF to w, one
+// segment at a time, so memory use stays bounded whatever the input size.
+// Cancelling ctx stops the encrypt at the next segment boundary.
+func (tdfsdk *tdfNative) EncryptStream(ctx context.Context, r io.Reader, w io.Writer, opts EncryptOptions) error {
+	if err := tdfsdk.encryptReader(ctx, r, w, opts); err != nil {
+		tdfsdk.logger.Errorf("Error encrypting stream! Error was %s", err)
+		return err
+	}
+	return nil
+}
+
+// EncryptTo streams the TDF to out as it is encrypted, so e.g. an S3 upload
+// proceeds part by part rather than after the whole TDF is built.
+func (tdfsdk *tdfNative) EncryptTo(ctx context.Context, data *TDFStorage, out *TDFOutputStorage, opts EncryptOptions) error {
+	err := writeTDF(ctx, out, func(w io.Writer) error {
+		return tdfsdk.encrypt(ctx, data, w, opts)
+	})
+	if err != nil {
+		tdfsdk.logger.Errorf("Error encrypting to %s! Error was %s", out.Descriptor(), err)
+		return err
+	}
+	return nil
+}
+
+func (tdfsdk *tdfNative) DecryptTDF(data *TDFStorage) (string, error) {
+	return tdfsdk.DecryptTDFContext(context.Background(), data)
+}
+
+func (tdfsdk *tdfNative) DecryptTDFContext(ctx context.Context, data *TDFStorage) (string, error) {
+	plaintext, err := tdfsdk.DecryptBytes(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(plaintext)
+	return string(plaintext), nil
+}
+
+func (tdfsdk *tdfNative) DecryptBytes(ctx context.Context, data *TDFStorage) ([]byte, error) {
+	decryptor, err := tdfsdk.newDecryptor(ctx, data)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error decrypting bytes!, error was %s", err)
+		return nil, err
+	}
+	plaintext := decryptor.NewReader()
+	defer plaintext.Close()
+
+	buf := make([]byte, decryptor.PlaintextSize())
+	if _, err := io.ReadFull(&contextReader{ctx: ctx, r: plaintext}, buf); err != nil {
+		wipeBytes(buf)
+		tdfsdk.logger.Errorf("Error decrypting bytes!, error was %s", err)
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (tdfsdk *tdfNative) DecryptSecure(ctx context.Context, data *TDFStorage) (*SecureBuffer, error) {
+	plaintext, err := tdfsdk.DecryptBytes(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecureBuffer(plaintext), nil
+}
+
+func (tdfsdk *tdfNative) DecryptTDFPartial(data *TDFStorage, offset, length uint32) (string, error) {
+	return tdfsdk.DecryptTDFPartialContext(context.Background(), data, offset, length)
+}
+
+func (tdfsdk *tdfNative) DecryptTDFPartialContext(ctx context.Context, data *TDFStorage, offset, length uint32) (string, error) {
+	plain, err := tdfsdk.DecryptPartialBytes(ctx, data, offset, length)
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(plain)
+	return string(plain), nil
+}
+
+func (tdfsdk *tdfNative) DecryptPartialBytes(ctx context.Context, data *TDFStorage, offset, length uint32) ([]byte, error) {
+	return tdfsdk.DecryptRange(ctx, data, int64(offset), int64(length))
+}
+
+// DecryptRange reads and decrypts only the payload segments covering the requested
+// range, so random access into very large TDFs stays cheap.
+func (tdfsdk *tdfNative) DecryptRange(ctx context.Context, data *TDFStorage, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "DecryptRange", Err: errors.New("negative offset or length")}
+	}
+	decryptor, err := tdfsdk.newDecryptor(ctx, data)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error decrypting partial bytes!, error was %s", err)
+		return nil, err
+	}
+	defer decryptor.Close()
+
+	plain, err := decryptor.DecryptRange(offset, length)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error decrypting partial bytes!, error was %s", err)
+		return nil, err
+	}
+	return plain, nil
+}
+
+// NewReaderAt rewraps the payload key up front, then decrypts segments as they are read.
+func (tdfsdk *tdfNative) NewReaderAt(ctx context.Context, data *TDFStorage, cacheSegments int) (*TDFReaderAt, error) {
+	//Reads outlive the call that opened the reader, so they don't use its ctx
+	decryptor, err := tdfsdk.newDecryptorReading(ctx, context.Background(), data)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error opening TDF for random access!, error was %s", err)
+		return nil, err
+	}
+	decrypt := func(seg tdf3.SegmentInfo) ([]byte, error) {
+		return decryptor.DecryptSegment(seg.Index)
+	}
+	return newTDFReaderAt(decryptor.Segments(), cacheSegments, decrypt, decryptor.Close), nil
+}
+
+// DecryptStream returns a reader over the decrypted TDF payload. Segments are
+// decrypted and integrity checked as they are read, and the reader also
+// implements io.Seeker and io.ReaderAt. Segments are read from storage with ctx,
+// so cancelling it fails the reads still to come.
+func (tdfsdk *tdfNative) DecryptStream(ctx context.Context, data *TDFStorage) (io.ReadCloser, error) {
+	decryptor, err := tdfsdk.newDecryptor(ctx, data)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error decrypting stream!, error was %s", err)
+		return nil, err
+	}
+	return decryptor.NewReader(), nil
+}
+
+func (tdfsdk *tdfNative) GetEncryptedMetadata(data *TDFStorage) (string, error) {
+	ctx := context.Background()
+	reader, err := openTDF(ctx, data)
+	if err != nil {
+		return "", err
+	}
+
+	info := reader.EncryptionInformation()
+	for _, kao := range info.KeyAccess {
+		if kao.EncryptedMetadata == "" {
+			continue
+		}
+		key, err := tdfsdk.rewrap(ctx, kao, info.Policy)
+		if err != nil {
+			tdfsdk.logger.Errorf("Error getting encrypted metadata from TDF!, error was %s", err)
+			return "", err
+		}
+		metadata, err := tdf3.DecryptMetadata(key, kao.EncryptedMetadata)
+		wipeBytes(key)
+		return metadata, err
+	}
+	return "", nil
+}
+
+func (tdfsdk *tdfNative) GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error) {
+	return tdfsdk.GetPolicyFromTDFContext(context.Background(), data)
+}
+
+// The policy is read straight from the manifest, so this makes no OIDC or KAS calls.
+func (tdfsdk *tdfNative) GetPolicyFromTDFContext(ctx context.Context, data *TDFStorage) (*TDFPolicy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	reader, err := openTDF(ctx, data)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error getting policy from TDF file! Error was %s", err)
+		return nil, err
+	}
+
+	policyJSON, err := base64.StdEncoding.DecodeString(reader.EncryptionInformation().Policy)
+	if err != nil {
+		tdfsdk.logger.Errorf("Error decoding policy obtained from TDF file! Error was %s", err)
+		return nil, &TDFError{Kind: ErrPolicy, Op: "GetPolicyFromTDF", Err: err}
+	}
+	var tdfPolicy TDFPolicy
+	if err := json.Unmarshal(policyJSON, &tdfPolicy); err != nil {
+		tdfsdk.logger.Errorw("Error parsing policy JSON string obtained from TDF file!", LogKeyPolicy, policyJSON, "error", err)
+		return nil, &TDFError{Kind: ErrPolicy, Op: "GetPolicyFromTDF", Err: err}
+	}
+	return &tdfPolicy, nil
+}
+
+func (tdfsdk *tdfNative) GetStorageTypeDescriptor(data *TDFStorage) (string, error) {
+	return data.source.descriptor(), nil
+}
+
+func (tdfsdk *tdfNative) encrypt(ctx context.Context, data *TDFStorage, w io.Writer, opts EncryptOptions) error {
+	in, err := data.plaintextStream(ctx)
+	if err != nil {
+		return &TDFError{Kind: ErrInvalidParams, Op: "open TDF storage", Err: err}
+	}
+	return tdfsdk.encryptReader(ctx, in, w, opts)
+}
+
+// encryptReader only reads the client's immutable settings, so concurrent encrypts never see each other's options.
+func (tdfsdk *tdfNative) encryptReader(ctx context.Context, r io.Reader, w io.Writer, opts EncryptOptions) error {
+	kasURL := opts.KASURL
+	if kasURL == "" {
+		kasURL = tdfsdk.kasURL
+	}
+	kasKey, err := tdfsdk.kasPublicKey(ctx, kasURL)
+	if err != nil {
+		return err
+	}
+
+	var policy tdf3.Policy
+	for _, attr := range opts.DataAttributes {
+		policy.Body.DataAttributes = append(policy.Body.DataAttributes, tdf3.Attribute{Attribute: attr})
+	}
+
+	_, err = tdf3.Encrypt(w, &contextReader{ctx: ctx, r: r}, tdf3.EncryptOptions{
+		KAS:      []tdf3.KASInfo{{URL: kasURL, PublicKey: kasKey}},
+		Policy:   policy,
+		Metadata: opts.Metadata,
+	})
+	return err
+}
+
+// newDecryptor rewraps the payload key with the first KAS that grants it, and
+// returns a decryptor for the TDF payload.
+func (tdfsdk *tdfNative) newDecryptor(ctx context.Context, data *TDFStorage) (*tdf3.Decryptor, error) {
+	return tdfsdk.newDecryptorReading(ctx, ctx, data)
+}
+
+// newDecryptorReading is newDecryptor for a decryptor that reads the TDF with readCtx.
+func (tdfsdk *tdfNative) newDecryptorReading(ctx, readCtx context.Context, data *TDFStorage) (*tdf3.Decryptor, error) {
+	reader, err := openTDF(readCtx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := reader.EncryptionInformation()
+	if len(info.KeyAccess) == 0 {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "read TDF", Err: errors.New("no key access objects")}
+	}
+	var rewrapErr error
+	for _, kao := range info.KeyAccess {
+		key, err := tdfsdk.rewrap(ctx, kao, info.Policy)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			rewrapErr = err
+			continue
+		}
+		decryptor, err := reader.NewDecryptor(key)
+		wipeBytes(key)
+		return decryptor, err
+	}
+	return nil, rewrapErr
+}
+
+// contextReader fails reads once its context is done, which lets long running
+// streaming operations be abandoned between segments.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Run with -race: goroutines sharing one client must each get their own
+// attributes and metadata, and nothing else's.
+func TestConcurrentEncryptWithOptions(t *testing.T) {
+	idp, kas := newTestIdP(t), newTestKAS(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+	ctx := context.Background()
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- encryptAndCheck(ctx, tdfClient, kas, i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func encryptAndCheck(ctx context.Context, tdfClient TDFClient, kas *testKAS, i int) error {
+	plaintext := fmt.Sprintf("plaintext %d", i)
+	attribute := fmt.Sprintf("https://example.com/attr/Worker/value/%d", i)
+	metadata := fmt.Sprintf("metadata %d", i)
+
+	storage, err := NewTDFStorageString(plaintext)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	tdf, err := tdfClient.EncryptWithOptions(ctx, storage, EncryptOptions{Metadata: metadata, DataAttributes: []string{attribute}})
+	if err != nil {
+		return fmt.Errorf("worker %d: EncryptWithOptions() error = %v", i, err)
+	}
+
+	policy, gotMetadata, err := readTestTDF(kas, tdf)
+	if err != nil {
+		return fmt.Errorf("worker %d: reading TDF: %v", i, err)
+	}
+	if attrs := policy.Body.DataAttributes; len(attrs) != 1 || attrs[0].Attribute != attribute {
+		return fmt.Errorf("worker %d: policy attributes = %v, want only %s", i, attrs, attribute)
+	}
+	if gotMetadata != metadata {
+		return fmt.Errorf("worker %d: metadata = %q, want %q", i, gotMetadata, metadata)
+	}
+
+	tdfStorage, err := NewTDFStorageBytes(tdf)
+	if err != nil {
+		return err
+	}
+	defer tdfStorage.Close()
+	decrypted, err := tdfClient.DecryptTDFContext(ctx, tdfStorage)
+	if err != nil {
+		return fmt.Errorf("worker %d: DecryptTDFContext() error = %v", i, err)
+	}
+	if decrypted != plaintext {
+		return fmt.Errorf("worker %d: decrypted %q, want %q", i, decrypted, plaintext)
+	}
+	return nil
+}
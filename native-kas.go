@@ -0,0 +1,218 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+const (
+	// Upper bound on any OIDC/KAS response body we are willing to read
+	maxResponseSize = 1 << 20
+
+	rewrapAlgorithm = "rsa:2048"
+)
+
+type kasRewrapRequestBody struct {
+	Algorithm       string         `json:"algorithm"`
+	KeyAccess       tdf3.KeyAccess `json:"keyAccess"`
+	Policy          string         `json:"policy"`
+	ClientPublicKey string         `json:"clientPublicKey"`
+}
+
+type kasRewrapResponse struct {
+	EntityWrappedKey string `json:"entityWrappedKey"`
+}
+
+// kasPublicKey fetches (and caches) the public key that payload keys are wrapped with for the given KAS.
+func (tdfsdk *tdfNative) kasPublicKey(ctx context.Context, kasURL string) (*rsa.PublicKey, error) {
+	tdfsdk.kasKeys.mu.Lock()
+	pub, ok := tdfsdk.kasKeys.keys[kasURL]
+	tdfsdk.kasKeys.mu.Unlock()
+	if ok {
+		return pub, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(kasURL, "/")+"/kas_public_key", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tdfsdk.httpClient.Do(req)
+	if err != nil {
+		return nil, newNetworkError("KAS public key", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, newNetworkError("KAS public key", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError("KAS public key", resp.StatusCode)
+	}
+
+	pub, err = parseKASPublicKey(body)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrFailure, Op: "KAS public key", Err: err}
+	}
+	tdfsdk.kasKeys.mu.Lock()
+	tdfsdk.kasKeys.keys[kasURL] = pub
+	tdfsdk.kasKeys.mu.Unlock()
+	return pub, nil
+}
+
+// KAS serves its public key either as a JSON string, a JSON object with a
+// publicKey field, or bare PEM; the PEM may hold a key or a certificate.
+func parseKASPublicKey(body []byte) (*rsa.PublicKey, error) {
+	pemData := body
+	var asString string
+	var asObject struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if json.Unmarshal(body, &asString) == nil {
+		pemData = []byte(asString)
+	} else if json.Unmarshal(body, &asObject) == nil && asObject.PublicKey != "" {
+		pemData = []byte(asObject.PublicKey)
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("KAS public key is not valid PEM")
+	}
+
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = cert.PublicKey
+	default:
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("KAS public key is not an RSA key")
+	}
+	return rsaPub, nil
+}
+
+// rewrap asks the KAS named in the key access object to rewrap the payload key
+// with our client public key, and returns the unwrapped payload key.
+func (tdfsdk *tdfNative) rewrap(ctx context.Context, kao tdf3.KeyAccess, base64Policy string) ([]byte, error) {
+	requestBody, err := json.Marshal(kasRewrapRequestBody{
+		Algorithm:       rewrapAlgorithm,
+		KeyAccess:       kao,
+		Policy:          base64Policy,
+		ClientPublicKey: tdfsdk.clientPublicKeyPEM,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signedToken, err := signRequestToken(tdfsdk.clientKey, string(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(map[string]string{"signedRequestToken": signedToken})
+	if err != nil {
+		return nil, err
+	}
+
+	body, retry, err := tdfsdk.postRewrap(ctx, kao.URL, payload)
+	if retry {
+		//The access token expired or was revoked early - try once more with a fresh one
+		body, _, err = tdfsdk.postRewrap(ctx, kao.URL, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrapResp kasRewrapResponse
+	if err := json.Unmarshal(body, &rewrapResp); err != nil {
+		return nil, &TDFError{Kind: ErrFailure, Op: "KAS rewrap", Err: err}
+	}
+	key, err := tdf3.UnwrapKey(tdfsdk.clientKey, rewrapResp.EntityWrappedKey)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrFailure, Op: "KAS rewrap", Err: err}
+	}
+	return key, nil
+}
+
+// postRewrap sends a rewrap request with a current access token, and returns the response body.
+// If KAS rejects the token as unauthorized, it is dropped so the next call gets a new one,
+// and retry reports whether that one can differ.
+func (tdfsdk *tdfNative) postRewrap(ctx context.Context, kasURL string, payload []byte) (body []byte, retry bool, err error) {
+	accessToken, err := tdfsdk.creds.token(ctx, tdfsdk.clientPublicKeyPEM)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(kasURL, "/")+"/v2/rewrap", bytes.NewReader(payload))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := tdfsdk.httpClient.Do(req)
+	if err != nil {
+		return nil, false, newNetworkError("KAS rewrap", err)
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, false, newNetworkError("KAS rewrap", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			retry = tdfsdk.creds.expire(accessToken)
+		}
+		return nil, retry, newHTTPStatusError("KAS rewrap", resp.StatusCode)
+	}
+	return body, false, nil
+}
+
+// signRequestToken wraps the rewrap request body in an RS256 JWT signed with the client key.
+func signRequestToken(key *rsa.PrivateKey, requestBody string) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"requestBody": requestBody,
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	grantTypeRefreshToken      = "refresh_token"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+
+	// Tokens are refreshed this long before they actually expire, so they
+	// don't lapse while a KAS request is in flight.
+	tokenExpiryLeeway = 30 * time.Second
+)
+
+// accessTokenSource supplies the bearer tokens the native client sends to KAS.
+// clientPublicKeyPEM is the key KAS rewraps to, which the IdP binds into the token.
+type accessTokenSource interface {
+	token(ctx context.Context, clientPublicKeyPEM string) (string, error)
+	// expire drops accessToken from any cache, after KAS rejected it, and reports
+	// whether the next token can differ, so the request is worth retrying
+	expire(accessToken string) bool
+}
+
+// oidcCredentials fetches access tokens from a Keycloak OIDC token endpoint, the
+// same way client-cpp does. The client public key is sent in the X-VirtruPubKey
+// header so the IdP can bind it into the token, which KAS checks on rewrap.
+type oidcCredentials struct {
+	tokenURL string
+	// Holds the client secret and external access token as strings, which can't
+	// be wiped; they reach us as strings from the options anyway, and net/http
+	// makes its own copies of every request body.
+	form       url.Values
+	httpClient *http.Client
+
+	// Held while a token is being fetched, so concurrent callers share one
+	// fetch. A channel rather than a mutex, so waiters can give up on ctx.
+	// Also guards every field below, and form.
+	lock        chan struct{}
+	accessToken string
+	expiry      time.Time
+	// Used in place of the form's grant while the IdP accepts it
+	refreshToken  string
+	refreshExpiry time.Time
+	// When the external access token of a token exchange expires, if it says
+	subjectExpiry time.Time
+
+	// Used in place of posting form for grants that need the user, if set
+	interactive interactiveLogin
+	// Where refresh tokens are kept between runs, if anywhere
+	cache *tokenCache
+}
+
+type oidcTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+type oidcErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oidcError is the OAuth error code an IdP endpoint responded with.
+type oidcError struct {
+	Code        string
+	Description string
+}
+
+func (e *oidcError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func newOIDCClientCredentials(oidcURL, orgName, clientId, clientSecret string, httpClient *http.Client) *oidcCredentials {
+	return &oidcCredentials{
+		tokenURL: oidcTokenURL(oidcURL, orgName),
+		form: url.Values{
+			"grant_type":    {grantTypeClientCredentials},
+			"client_id":     {clientId},
+			"client_secret": {clientSecret},
+		},
+		httpClient: httpClient,
+		lock:       make(chan struct{}, 1),
+	}
+}
+
+// The client authenticates with its TLS client certificate, so the form has no secret.
+func newOIDCClientCertificate(oidcURL, orgName, clientId string, httpClient *http.Client) *oidcCredentials {
+	return &oidcCredentials{
+		tokenURL: oidcTokenURL(oidcURL, orgName),
+		form: url.Values{
+			"grant_type": {grantTypeClientCredentials},
+			"client_id":  {clientId},
+		},
+		httpClient: httpClient,
+		lock:       make(chan struct{}, 1),
+	}
+}
+
+func newOIDCTokenExchange(oidcURL, orgName, clientId, clientSecret, externalAccessToken string, httpClient *http.Client) *oidcCredentials {
+	return &oidcCredentials{
+		tokenURL: oidcTokenURL(oidcURL, orgName),
+		form: url.Values{
+			"grant_type":           {grantTypeTokenExchange},
+			"client_id":            {clientId},
+			"client_secret":        {clientSecret},
+			"subject_token":        {externalAccessToken},
+			"requested_token_type": {tokenTypeAccessToken},
+		},
+		httpClient:    httpClient,
+		lock:          make(chan struct{}, 1),
+		subjectExpiry: jwtExpiry(externalAccessToken),
+	}
+}
+
+func oidcRealmURL(oidcURL, orgName string) string {
+	return strings.TrimSuffix(oidcURL, "/") + "/auth/realms/" + url.PathEscape(orgName)
+}
+
+func oidcTokenURL(oidcURL, orgName string) string {
+	return oidcRealmURL(oidcURL, orgName) + "/protocol/openid-connect/token"
+}
+
+// useCache makes creds keep refresh tokens in cache, starting with the one it holds.
+func (creds *oidcCredentials) useCache(cache *tokenCache) {
+	creds.cache = cache
+	cached := cache.load()
+	creds.refreshToken, creds.refreshExpiry = cached.RefreshToken, cached.RefreshExpiry
+}
+
+// token returns a cached access token, fetching a new one if the cached token
+// is missing or about to expire. A refresh token is used if the IdP issued one,
+// falling back to the original grant if the IdP no longer accepts it.
+func (creds *oidcCredentials) token(ctx context.Context, clientPublicKeyPEM string) (string, error) {
+	select {
+	case creds.lock <- struct{}{}:
+		defer func() { <-creds.lock }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	now := time.Now()
+	if creds.accessToken != "" && now.Add(tokenExpiryLeeway).Before(creds.expiry) {
+		return creds.accessToken, nil
+	}
+
+	if creds.refreshToken != "" && (creds.refreshExpiry.IsZero() || now.Add(tokenExpiryLeeway).Before(creds.refreshExpiry)) {
+		refreshForm := url.Values{
+			"grant_type":    {grantTypeRefreshToken},
+			"refresh_token": {creds.refreshToken},
+			"client_id":     creds.form["client_id"],
+		}
+		if secret := creds.form.Get("client_secret"); secret != "" {
+			refreshForm.Set("client_secret", secret)
+		}
+		err := creds.fetch(ctx, refreshForm, clientPublicKeyPEM)
+		if err == nil {
+			return creds.accessToken, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+	}
+	if creds.refreshToken != "" {
+		creds.refreshToken = ""
+		if creds.cache != nil {
+			creds.cache.store(cachedToken{})
+		}
+	}
+
+	if creds.form.Get("grant_type") == grantTypeTokenExchange && !creds.subjectExpiry.IsZero() && now.After(creds.subjectExpiry) {
+		return "", &TDFError{Kind: ErrTokenExpired, Op: "OIDC token",
+			Err: fmt.Errorf("external access token expired at %s", creds.subjectExpiry.Format(time.RFC3339))}
+	}
+	var err error
+	if creds.interactive != nil {
+		err = creds.interactive.login(ctx, creds, clientPublicKeyPEM)
+	} else {
+		err = creds.fetch(ctx, creds.form, clientPublicKeyPEM)
+	}
+	if err != nil {
+		return "", err
+	}
+	return creds.accessToken, nil
+}
+
+// fetch posts form to the token endpoint and caches the tokens it returns.
+func (creds *oidcCredentials) fetch(ctx context.Context, form url.Values, clientPublicKeyPEM string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-VirtruPubKey", base64.StdEncoding.EncodeToString([]byte(clientPublicKeyPEM)))
+
+	resp, err := creds.httpClient.Do(req)
+	if err != nil {
+		return newNetworkError("OIDC token", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return newNetworkError("OIDC token", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		tdfErr := newHTTPStatusError("OIDC token", resp.StatusCode).(*TDFError)
+		var errResp oidcErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			tdfErr.Err = &oidcError{Code: errResp.Error, Description: errResp.ErrorDescription}
+			//The IdP rejects an expired external access token as an invalid subject token, but also a
+			//revoked or malformed one, so only blame expiry if it has (near enough, given clock skew) passed
+			if form.Get("grant_type") == grantTypeTokenExchange && (errResp.Error == "invalid_token" || errResp.Error == "invalid_grant") {
+				tdfErr.Kind = ErrAccessDenied
+				if !creds.subjectExpiry.IsZero() && time.Now().Add(tokenExpiryLeeway).After(creds.subjectExpiry) {
+					tdfErr.Kind = ErrTokenExpired
+				}
+			}
+		}
+		return tdfErr
+	}
+
+	var tokenResp oidcTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return &TDFError{Kind: ErrFailure, Op: "OIDC token", Err: err}
+	}
+	if tokenResp.AccessToken == "" {
+		return &TDFError{Kind: ErrFailure, Op: "OIDC token", Err: errors.New("response has no access token")}
+	}
+
+	now := time.Now()
+	creds.accessToken = tokenResp.AccessToken
+	creds.expiry = now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	if tokenResp.ExpiresIn == 0 {
+		creds.expiry = jwtExpiry(tokenResp.AccessToken)
+	}
+	creds.refreshToken, creds.refreshExpiry = tokenResp.RefreshToken, time.Time{}
+	if tokenResp.RefreshExpiresIn > 0 {
+		creds.refreshExpiry = now.Add(time.Duration(tokenResp.RefreshExpiresIn) * time.Second)
+	}
+	if creds.cache != nil {
+		creds.cache.store(cachedToken{RefreshToken: creds.refreshToken, RefreshExpiry: creds.refreshExpiry})
+	}
+	return nil
+}
+
+func (creds *oidcCredentials) expire(accessToken string) bool {
+	creds.lock <- struct{}{}
+	defer func() { <-creds.lock }()
+	if creds.accessToken == accessToken {
+		creds.accessToken = ""
+	}
+	return true
+}
+
+// setSubjectToken replaces the external access token of a token exchange. Tokens
+// obtained with the old one, including refresh tokens, are dropped.
+func (creds *oidcCredentials) setSubjectToken(externalAccessToken string) error {
+	creds.lock <- struct{}{}
+	defer func() { <-creds.lock }()
+	if creds.form.Get("grant_type") != grantTypeTokenExchange {
+		return errNotTokenExchange
+	}
+	creds.form.Set("subject_token", externalAccessToken)
+	creds.subjectExpiry = jwtExpiry(externalAccessToken)
+	creds.accessToken, creds.refreshToken = "", ""
+	return nil
+}
+
+var errNotTokenExchange = &TDFError{Kind: ErrInvalidParams, Op: "SetExternalAccessToken",
+	Err: errors.New("client does not use token exchange")}
+
+// jwtExpiry returns the exp claim of token if it is a JWT, without verifying it, or
+// the zero time if it isn't or has no exp. It is only used to fail early and clearly.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if json.Unmarshal(claimsJSON, &claims) != nil {
+		return time.Time{}
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil || exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
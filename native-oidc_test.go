@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testJWT returns an unsigned JWT that expires at exp, or has no exp claim if exp is zero.
+func testJWT(exp time.Time) string {
+	claims := `{"sub":"user"}`
+	if !exp.IsZero() {
+		claims = fmt.Sprintf(`{"sub":"user","exp":%d}`, exp.Unix())
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+// newRejectingExchangeIdP is a stand-in IdP that rejects every token exchange with invalid_grant.
+func newRejectingExchangeIdP(t *testing.T) *httptest.Server {
+	t.Helper()
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidcErrorResponse{Error: "invalid_grant"}) //nolint:errcheck
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestTokenExchangeRejected(t *testing.T) {
+	idp, kas := newRejectingExchangeIdP(t), newTestKAS(t)
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expiring", testJWT(time.Now().Add(5 * time.Second)), ErrTokenExpired},
+		{"expired", testJWT(time.Now().Add(-time.Minute)), ErrTokenExpired},
+		{"revoked", testJWT(time.Now().Add(time.Hour)), ErrAccessDenied},
+		{"opaque", "opaque-token", ErrAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tdfClient, err := NewTDFClient(WithBackend(BackendNative), WithTokenExchange("tdf", "tdf-client", "secret", tt.token),
+				WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+			if err != nil {
+				t.Fatalf("NewTDFClient() error = %v", err)
+			}
+			defer tdfClient.Close()
+
+			storage, err := NewTDFStorageString("plaintext")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+			tdf, err := tdfClient.EncryptWithOptions(context.Background(), storage, EncryptOptions{})
+			if err != nil {
+				t.Fatalf("EncryptWithOptions() error = %v", err)
+			}
+			tdfStorage, err := NewTDFStorageBytes(tdf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tdfStorage.Close()
+			if _, err := tdfClient.DecryptBytes(context.Background(), tdfStorage); !errors.Is(err, tt.want) {
+				t.Errorf("DecryptBytes() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,21 @@
+//go:build cgo
+
 package client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/opentdf/client-go/tdf3"
 	"go.uber.org/zap"
 )
 
@@ -17,6 +27,7 @@ import (
 //
 // #include <stdlib.h>
 // #include <stdbool.h>
+// #include <string.h>
 // #include <tdf_constants_c.h>
 // #include <tdf_client_c.h>
 //
@@ -51,159 +62,364 @@ type tdfCInterop struct {
 	cStringPointersToFree []*C.char
 	kasURL                string
 	logger                *zap.SugaredLogger
+	debugLogging          bool
+	// For token exchange, what SetExternalAccessToken needs to create new credentials
+	exchange *tokenExchangeConfig
+	// When the external access token expires, if it says. Guarded by expiryMu, since
+	// checkTDFStatus runs both with and without closeMu held.
+	externalTokenExpiry time.Time
+	expiryMu            sync.Mutex
+	// C SDK calls still running after their caller stopped waiting on a done context
+	inflight sync.WaitGroup
+	// Read-held for the duration of every C SDK call, write-held by Close
+	closeMu sync.RWMutex
+	// client-cpp clients are not safe for concurrent use, so calls on the shared sdkPtr are serialized
+	sdkMu sync.Mutex
+	// Encrypt clients waiting to be reused, oldest first
+	idleEncryptClients []*encryptClient
+	idleMu             sync.Mutex
 }
 
-// See https://github.com/opentdf/spec/blob/master/schema/AttributeObject.md
-// {
-// "attribute": "https://example.com/attr/classification/value/topsecret"
-// }
-type TDFAttribute struct {
-	Attribute string `json:"attribute"`
-}
-
-// See https://github.com/opentdf/spec/blob/master/schema/PolicyObject.md
-// {
-// "uuid": "1111-2222-33333-44444-abddef-timestamp",
-//
-//	"body": {
-//	   "dataAttributes": [<Attribute Object>],
-//	   "dissem": ["user-id@domain.com"]
-//	 },
-//
-// "tdf_spec_version:": "x.y.z"
-// }
-type TDFPolicy struct {
-	UUID        string        `json:"uuid"`
-	Body        TDFPolicyBody `json:"body"`
-	SpecVersion string        `json:"tdf_spec_version"`
-}
-
-type TDFPolicyBody struct {
-	DataAttributes    []TDFAttribute `json:"dataAttributes"`
-	DisseminationList []string       `json:"dissem"`
+// tokenExchangeConfig keeps a Go copy of the client secret, wiped on Close, since
+// client-cpp's copy can't be reused for new credentials.
+type tokenExchangeConfig struct {
+	oidcURL      string
+	orgName      string
+	clientId     string
+	clientSecret []byte
 }
 
+// cStorage holds the client-cpp side of a TDFStorage object.
 // Note that right now the client-cpp storage type only works for TDF INPUT data, not OUTPUT data.
 // This will be added eventually
-type TDFStorage struct {
+type cStorage struct {
 	storagePtr   C.TDFStorageTypePtr
 	thingsToFree []func()
+	inflight     sync.WaitGroup
+
+	// For storage over a Go reader, the C storage object is only created, from
+	// the data load returns, the first time a C SDK call needs it
+	load     func() ([]byte, error)
+	loadOnce sync.Once
+	loadErr  error
+
+	// Guards closed and storagePtr between ptr and close, which can run on different goroutines
+	mu     sync.Mutex
+	closed bool
 }
 
-type TDFClient interface {
-	Close()
-	EncryptToFile(data *TDFStorage, outFile, metadata string, dataAttribs []string) error
-	EncryptToString(data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error)
-	GetEncryptedMetadata(data *TDFStorage) (string, error)
-	DecryptTDF(data *TDFStorage) (string, error)
-	DecryptTDFPartial(data *TDFStorage, offset, length uint32) (string, error)
-	GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error)
-	GetStorageTypeDescriptor(data *TDFStorage) (string, error)
+var errStorageClosed = &TDFError{Kind: ErrInvalidParams, Op: "TDFStorage", Err: errors.New("storage is closed")}
+
+// cString is C.CString, recorded in leak debug mode. Free it with freeCString.
+func cString(s string) *C.char {
+	cstr := C.CString(s)
+	cAllocs.track(unsafe.Pointer(cstr), "C string")
+	return cstr
 }
 
-// Creates a new S3-based TDF storage object
-func NewTDFStorageS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion string) (*TDFStorage, error) {
-	inS3Url := C.CString(s3url)
-	inKeyId := C.CString(awsAccessKeyID)
-	inSecretKey := C.CString(awsSecretKey)
-	inRegion := C.CString(awsRegion)
-	thingsToFree := []func(){
-		func() { C.free(unsafe.Pointer(inS3Url)) },
-		func() { C.free(unsafe.Pointer(inKeyId)) },
-		func() { C.free(unsafe.Pointer(inSecretKey)) },
-		func() { C.free(unsafe.Pointer(inRegion)) },
+// cStringBytes is cString for a secret held in a byte slice. It copies b straight
+// into C memory, leaving no Go string copy behind that can't be wiped.
+func cStringBytes(b []byte) *C.char {
+	cstr := (*C.char)(C.malloc(C.size_t(len(b) + 1)))
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(cstr)), len(b)+1)
+	copy(buf, b)
+	buf[len(b)] = 0
+	cAllocs.track(unsafe.Pointer(cstr), "C string")
+	return cstr
+}
+
+// freeCString wipes and frees a string from cString, since it may hold a secret.
+func freeCString(cstr *C.char) {
+	cAllocs.untrack(unsafe.Pointer(cstr))
+	C.memset(unsafe.Pointer(cstr), 0, C.strlen(cstr))
+	C.free(unsafe.Pointer(cstr))
+}
+
+// freeCBytes wipes and frees a buffer the C SDK returned, since it may hold plaintext.
+func freeCBytes(ptr C.TDFBytesPtr, size C.TDFBytesLength) {
+	C.memset(unsafe.Pointer(ptr), 0, C.size_t(size))
+	C.free(unsafe.Pointer(ptr))
+}
+
+func (storage *cStorage) initS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion string) error {
+	inS3Url := cString(s3url)
+	inKeyId := cString(awsAccessKeyID)
+	inSecretKey := cString(awsSecretKey)
+	inRegion := cString(awsRegion)
+	storage.thingsToFree = []func(){
+		func() { freeCString(inS3Url) },
+		func() { freeCString(inKeyId) },
+		func() { freeCString(inSecretKey) },
+		func() { freeCString(inRegion) },
 	}
 
-	storagePtr := C.TDFCreateTDFStorageS3Type(inS3Url, inKeyId, inSecretKey, inRegion)
-	if storagePtr == nil {
-		return nil, errors.New("Could not initialize TDF C SDK TDF S3 storage object!")
+	storage.storagePtr = C.TDFCreateTDFStorageS3Type(inS3Url, inKeyId, inSecretKey, inRegion)
+	cAllocs.track(unsafe.Pointer(storage.storagePtr), "S3 storage object")
+	if storage.storagePtr == nil {
+		storage.close()
+		return errors.New("Could not initialize TDF C SDK TDF S3 storage object!")
 	}
-	storage := TDFStorage{storagePtr, thingsToFree}
-	return &storage, nil
+	return nil
 }
 
-// Creates a new file-based TDF storage object
-func NewTDFStorageFile(filepath string) (*TDFStorage, error) {
+func (storage *cStorage) initFile(filepath string) error {
+	inFile := cString(filepath)
+	storage.thingsToFree = []func(){func() { freeCString(inFile) }}
 
-	inFile := C.CString(filepath)
-	thingsToFree := []func(){func() { C.free(unsafe.Pointer(inFile)) }}
+	storage.storagePtr = C.TDFCreateTDFStorageFileType(inFile)
+	cAllocs.track(unsafe.Pointer(storage.storagePtr), "file storage object")
+	if storage.storagePtr == nil {
+		storage.close()
+		return errors.New("Could not initialize TDF C SDK TDF file storage object!")
+	}
+	return nil
+}
 
-	storagePtr := C.TDFCreateTDFStorageFileType(inFile)
-	if storagePtr == nil {
-		return nil, errors.New("Could not initialize TDF C SDK TDF file storage object!")
+func (storage *cStorage) initBytes(data []byte) error {
+	inSize, inPtr := convertGoBufToCBuf(data)
+
+	storage.storagePtr = C.TDFCreateTDFStorageStringType(inPtr, (C.uint)(inSize))
+	cAllocs.track(unsafe.Pointer(storage.storagePtr), "string storage object")
+	if storage.storagePtr == nil {
+		return errors.New("Could not initialize TDF C SDK TDF string storage object!")
 	}
-	storage := TDFStorage{storagePtr, thingsToFree}
-	return &storage, nil
+	return nil
 }
 
-// Creates a new string-based TDF storage object
-func NewTDFStorageString(data string) (*TDFStorage, error) {
-	inData := []byte(data)
-	inSize, inPtr := convertGoBufToCBuf(inData)
+func (storage *cStorage) initLazy(load func() ([]byte, error)) {
+	storage.load = load
+}
 
-	storagePtr := C.TDFCreateTDFStorageStringType(inPtr, (C.uint)(inSize))
-	if storagePtr == nil {
-		return nil, errors.New("Could not initialize TDF C SDK TDF string storage object!")
+// ptr returns the C storage object, creating it first for lazily initialized storage.
+func (storage *cStorage) ptr() (C.TDFStorageTypePtr, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if storage.closed {
+		return nil, errStorageClosed
+	}
+	if storage.load != nil {
+		storage.loadOnce.Do(func() {
+			data, err := storage.load()
+			if err == nil {
+				err = storage.initBytes(data)
+			}
+			if err != nil {
+				storage.loadErr = &TDFError{Kind: ErrInvalidParams, Op: "open TDF storage", Err: err}
+			}
+		})
 	}
-	storage := TDFStorage{storagePtr, nil}
-	return &storage, nil
+	return storage.storagePtr, storage.loadErr
 }
 
-// Should be invoked by caller when it's done with the storage location.
-// Note that callers MUST invoke Close() on the TDFStorage object
-// when they're done with it, ideally via a `defer storage.Close()`
-// If this does not happen, memory manually allocated in the C memspace will not be freed
-func (storage *TDFStorage) Close() {
-	C.TDFDestroyStorage(storage.storagePtr)
+func (storage *cStorage) close() {
+	//A C call abandoned via its context may still be using this storage
+	storage.inflight.Wait()
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.closed = true
+	if storage.storagePtr != nil {
+		cAllocs.untrack(unsafe.Pointer(storage.storagePtr))
+		C.TDFDestroyStorage(storage.storagePtr)
+		storage.storagePtr = nil
+	}
 	//Free up resources (cstrings, etc) created as part of this storage object
-	defer func() {
-		for _, f := range storage.thingsToFree {
-			f()
-		}
-	}()
+	for _, f := range storage.thingsToFree {
+		f()
+	}
+	storage.thingsToFree = nil
 }
 
-// Creates a new TDF client that will use OIDC client secret credentials to authenticate.
-func NewTDFClientOIDC(email string, orgName string, clientId string, clientSecret string, oidcURL string, kasURL string, logger *zap.Logger) TDFClient {
-	cSDK := tdfCInterop{logger: logger.Sugar(), kasURL: kasURL}
-	cSDK.initializeOIDCClient(C.CString(email), C.CString(orgName), C.CString(clientId), C.CString(clientSecret), C.CString(oidcURL), C.CString(kasURL))
+// finalize frees the C side of storage that was garbage collected without being
+// closed. The Go side needs no help: open files have finalizers of their own.
+func (storage *cStorage) finalize() {
+	cAllocs.reportFinalized(unsafe.Pointer(storage.storagePtr), "TDFStorage")
+	storage.close()
+}
 
-	//If Zap logging level == debug, then make TDF SDK internal request logging very verbose
-	if zapDebug := logger.Check(zap.DebugLevel, "debugging"); zapDebug != nil {
-		cSDK.checkTDFStatus(C.TDFEnableConsoleLogging(cSDK.sdkPtr, C.TDFLogLevelDebug), "TDFEnableConsoleLogging")
+// The client-cpp backend is only available when built with cgo
+const cppBackendAvailable = true
+
+func newCPPClient(cfg *clientConfig) (TDFClient, error) {
+	cSDK := tdfCInterop{logger: cfg.logger.Sugar(), kasURL: cfg.kasURL}
+	var err error
+	switch cfg.authMode {
+	case AuthTokenExchange:
+		cSDK.exchange = &tokenExchangeConfig{oidcURL: cfg.oidcURL, orgName: cfg.orgName, clientId: cfg.clientId, clientSecret: []byte(cfg.clientSecret)}
+		cSDK.externalTokenExpiry = jwtExpiry(cfg.externalAccessToken)
+		err = cSDK.initializeOIDCClientTokenExchange(cString(cfg.email), cString(cfg.orgName), cString(cfg.clientId), cString(cfg.clientSecret), cString(cfg.externalAccessToken), cString(cfg.oidcURL), cString(cfg.kasURL))
+	case AuthClientCertificate:
+		err = cSDK.initializeOIDCClientPKI(cString(cfg.email), cString(cfg.orgName), cString(cfg.clientId), cString(cfg.certFile), cString(cfg.keyFile), cString(cfg.caFile), cString(cfg.oidcURL), cString(cfg.kasURL))
+	default:
+		err = cSDK.initializeOIDCClient(cString(cfg.email), cString(cfg.orgName), cString(cfg.clientId), cString(cfg.clientSecret), cString(cfg.oidcURL), cString(cfg.kasURL))
 	}
-	return &cSDK
+	if err != nil {
+		cSDK.Close()
+		return nil, err
+	}
+
+	//If Zap logging level == debug, then make TDF SDK internal request logging very verbose.
+	//That logging goes straight to the console and can't be redacted, so only when the log policy allows everything
+	if zapDebug := cfg.logger.Check(zap.DebugLevel, "debugging"); zapDebug != nil && cfg.logPolicy.allowsAll() {
+		cSDK.debugLogging = true
+		cSDK.enableDebugLogging(cSDK.sdkPtr)
+	}
+	//A safety net only: the finalizer may run late or not at all, so callers must still Close
+	runtime.SetFinalizer(&cSDK, (*tdfCInterop).finalize)
+	return &cSDK, nil
 }
 
-// Creates a new TDF client that will use OIDC token exchange credentials to authenticate.
-func NewTDFClientOIDCTokenExchange(email, orgName, clientId, clientSecret, externalAccessToken, oidcURL, kasURL string, logger *zap.Logger) TDFClient {
-	cSDK := tdfCInterop{logger: logger.Sugar(), kasURL: kasURL}
-	cSDK.initializeOIDCClientTokenExchange(C.CString(email), C.CString(orgName), C.CString(clientId), C.CString(clientSecret), C.CString(externalAccessToken), C.CString(oidcURL), C.CString(kasURL))
+func (tdfsdk *tdfCInterop) finalize() {
+	cAllocs.reportFinalized(unsafe.Pointer(tdfsdk.sdkPtr), "TDFClient")
+	tdfsdk.Close()
+}
 
-	//If Zap logging level == debug, then make TDF SDK internal request logging very verbose
-	if zapDebug := logger.Check(zap.DebugLevel, "debugging"); zapDebug != nil {
-		cSDK.checkTDFStatus(C.TDFEnableConsoleLogging(cSDK.sdkPtr, C.TDFLogLevelDebug), "TDFEnableConsoleLogging")
+func (tdfsdk *tdfCInterop) enableDebugLogging(sdkPtr C.TDFClientPtr) {
+	if tdfsdk.debugLogging {
+		//nolint:errcheck
+		tdfsdk.checkTDFStatus(C.TDFEnableConsoleLogging(sdkPtr, C.TDFLogLevelDebug), "TDFEnableConsoleLogging")
 	}
-	return &cSDK
 }
 
 // Destroys the TDFClient instance.
 // Note that callers MUST invoke Close() on the TDFClient
 // when they're done with it, ideally via a `defer TDFClient.Close()`
-// If this does not happen, memory manually allocated in the C memspace will not be freed
+// If this does not happen, memory manually allocated in the C memspace is only freed
+// if and when the client is garbage collected. Calling Close more than once is safe.
 func (tdfsdk *tdfCInterop) Close() {
-	C.TDFDestroyClient(tdfsdk.sdkPtr)
-	C.TDFDestroyCredential(tdfsdk.credsPtr)
+	//A C call abandoned via its context may still be using this client
+	tdfsdk.inflight.Wait()
+	tdfsdk.closeMu.Lock()
+	defer tdfsdk.closeMu.Unlock()
+	runtime.SetFinalizer(tdfsdk, nil)
+	tdfsdk.destroyIdleEncryptClients()
+	if tdfsdk.sdkPtr != nil {
+		cAllocs.untrack(unsafe.Pointer(tdfsdk.sdkPtr))
+		C.TDFDestroyClient(tdfsdk.sdkPtr)
+		tdfsdk.sdkPtr = nil
+	}
+	if tdfsdk.credsPtr != nil {
+		cAllocs.untrack(unsafe.Pointer(tdfsdk.credsPtr))
+		C.TDFDestroyCredential(tdfsdk.credsPtr)
+		tdfsdk.credsPtr = nil
+	}
 
 	for _, cstrPnter := range tdfsdk.cStringPointersToFree {
-		C.free(unsafe.Pointer(cstrPnter))
+		freeCString(cstrPnter)
+	}
+	tdfsdk.cStringPointersToFree = nil
+	if tdfsdk.exchange != nil {
+		wipeBytes(tdfsdk.exchange.clientSecret)
+	}
+}
+
+// SetExternalAccessToken creates new client-cpp credential and client objects for the
+// new token, once calls in progress on the old ones have finished, and destroys the old ones.
+func (tdfsdk *tdfCInterop) SetExternalAccessToken(externalAccessToken string) error {
+	if tdfsdk.exchange == nil {
+		return errNotTokenExchange
+	}
+	tdfsdk.closeMu.Lock()
+	defer tdfsdk.closeMu.Unlock()
+	if tdfsdk.sdkPtr == nil {
+		return errClientClosed
 	}
+
+	oidcURL, orgName, clientId, kasURL := cString(tdfsdk.exchange.oidcURL), cString(tdfsdk.exchange.orgName), cString(tdfsdk.exchange.clientId), cString(tdfsdk.kasURL)
+	clientSecret, token := cStringBytes(tdfsdk.exchange.clientSecret), cString(externalAccessToken)
+	defer func() {
+		for _, cstr := range []*C.char{oidcURL, orgName, clientId, kasURL, clientSecret, token} {
+			freeCString(cstr)
+		}
+	}()
+
+	credsPtr := C.TDFCreateCredentialTokenExchange(oidcURL, clientId, clientSecret, token, orgName)
+	if credsPtr == nil {
+		tdfsdk.logger.Error("Could not initialize TDF C SDK credential object!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateCredentialTokenExchange"}
+	}
+	sdkPtr := C.TDFCreateClient(credsPtr, kasURL)
+	if sdkPtr == nil {
+		C.TDFDestroyCredential(credsPtr)
+		tdfsdk.logger.Error("Could not initialize TDF C SDK!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateClient"}
+	}
+	cAllocs.track(unsafe.Pointer(credsPtr), "credential object")
+	cAllocs.track(unsafe.Pointer(sdkPtr), "client object")
+
+	//Idle encrypt clients were made from the old credentials
+	tdfsdk.destroyIdleEncryptClients()
+	cAllocs.untrack(unsafe.Pointer(tdfsdk.sdkPtr))
+	C.TDFDestroyClient(tdfsdk.sdkPtr)
+	cAllocs.untrack(unsafe.Pointer(tdfsdk.credsPtr))
+	C.TDFDestroyCredential(tdfsdk.credsPtr)
+	tdfsdk.sdkPtr, tdfsdk.credsPtr = sdkPtr, credsPtr
+	tdfsdk.expiryMu.Lock()
+	tdfsdk.externalTokenExpiry = jwtExpiry(externalAccessToken)
+	tdfsdk.expiryMu.Unlock()
+	tdfsdk.enableDebugLogging(sdkPtr)
+	return nil
 }
 
 // EncryptToString takes a TDFStorage object containing the plaintext data to encrypt, an (optional, can be empty) string of metadata,
 // and a policy object, and encrypts the string + metadata with the policy, returning the encrypted string.
 func (tdfsdk *tdfCInterop) EncryptToString(data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error) {
-	return tdfsdk.encryptToString(data, tdfsdk.kasURL, metadata, dataAttribs)
+	return tdfsdk.encryptToString(data, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+func (tdfsdk *tdfCInterop) EncryptToStringContext(ctx context.Context, data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error) {
+	return tdfsdk.EncryptWithOptions(ctx, data, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+func (tdfsdk *tdfCInterop) EncryptWithOptions(ctx context.Context, data *TDFStorage, opts EncryptOptions) ([]byte, error) {
+	return runWithContext(tdfsdk, ctx, data, func() ([]byte, error) {
+		return tdfsdk.encryptToString(data, opts)
+	}, nil)
+}
+
+// EncryptStream reads plaintext from r until EOF and writes the encrypted TDF to w.
+// The C SDK cannot stream, so unlike the native client this buffers the whole
+// plaintext and TDF in memory.
+func (tdfsdk *tdfCInterop) EncryptStream(ctx context.Context, r io.Reader, w io.Writer, opts EncryptOptions) error {
+	plaintext, err := io.ReadAll(&contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return err
+	}
+	storage := TDFStorage{source: &bytesSource{data: plaintext}}
+	if err := storage.initBytes(plaintext); err != nil {
+		return err
+	}
+	//Close waits for the C call to finish, which must not hold us up if ctx is done
+	defer func() {
+		go func() {
+			storage.Close()
+			wipeBytes(plaintext)
+		}()
+	}()
+
+	tdf, err := tdfsdk.EncryptWithOptions(ctx, &storage, opts)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(tdf)
+	return err
+}
+
+// EncryptTo encrypts data and writes the TDF to out. The C SDK cannot stream, so the
+// whole TDF is built in memory before it is written.
+func (tdfsdk *tdfCInterop) EncryptTo(ctx context.Context, data *TDFStorage, out *TDFOutputStorage, opts EncryptOptions) error {
+	tdf, err := tdfsdk.EncryptWithOptions(ctx, data, opts)
+	if err != nil {
+		return err
+	}
+	err = writeTDF(ctx, out, func(w io.Writer) error {
+		_, err := w.Write(tdf)
+		return err
+	})
+	if err != nil {
+		tdfsdk.logger.Errorf("Error writing TDF to %s! Error was %s", out.Descriptor(), err)
+		return err
+	}
+	return nil
 }
 
 // DecryptTDF takes a a TDFStorage object containing encrypted TDF data, and decrypts the contents, returning the decrypted string.
@@ -211,15 +427,107 @@ func (tdfsdk *tdfCInterop) DecryptTDF(data *TDFStorage) (string, error) {
 	return tdfsdk.decryptBytes(data)
 }
 
+func (tdfsdk *tdfCInterop) DecryptTDFContext(ctx context.Context, data *TDFStorage) (string, error) {
+	plaintext, err := tdfsdk.DecryptBytes(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(plaintext)
+	return string(plaintext), nil
+}
+
 // DecryptTDFPartial takes a a TDFStorage object containing encrypted TDF data, and decrypts the from the given (plaintext) byte range, returning the decrypted plaintext for that range.
 func (tdfsdk *tdfCInterop) DecryptTDFPartial(data *TDFStorage, offset, length uint32) (string, error) {
 	return tdfsdk.decryptPartialBytes(data, offset, length)
 }
 
+// DecryptBytes is DecryptTDFContext for binary payloads, returning the plaintext without a string conversion.
+func (tdfsdk *tdfCInterop) DecryptBytes(ctx context.Context, data *TDFStorage) ([]byte, error) {
+	return runWithContext(tdfsdk, ctx, data, func() ([]byte, error) {
+		return tdfsdk.decryptToGoBytes(data)
+	}, wipeBytes)
+}
+
+// DecryptSecure is DecryptBytes returning the plaintext in a SecureBuffer. The C SDK's own
+// copy of the plaintext is wiped before it is freed.
+func (tdfsdk *tdfCInterop) DecryptSecure(ctx context.Context, data *TDFStorage) (*SecureBuffer, error) {
+	plaintext, err := tdfsdk.DecryptBytes(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecureBuffer(plaintext), nil
+}
+
+// DecryptPartialBytes is DecryptTDFPartialContext for binary payloads, returning the plaintext without a string conversion.
+func (tdfsdk *tdfCInterop) DecryptPartialBytes(ctx context.Context, data *TDFStorage, offset, length uint32) ([]byte, error) {
+	return runWithContext(tdfsdk, ctx, data, func() ([]byte, error) {
+		return tdfsdk.decryptPartialToGoBytes(data, offset, length)
+	}, wipeBytes)
+}
+
+// DecryptRange decrypts length bytes of plaintext from offset. The C SDK only takes 32-bit
+// offsets and lengths, so ranges ending past 4 GiB fail with ErrInvalidParams; use the
+// native backend for those.
+func (tdfsdk *tdfCInterop) DecryptRange(ctx context.Context, data *TDFStorage, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset > math.MaxUint32 || length > math.MaxUint32-offset {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "TDFDecryptDataPartial",
+			Err: errors.New("offset and length must be non-negative and end within 4 GiB")}
+	}
+	return tdfsdk.DecryptPartialBytes(ctx, data, uint32(offset), uint32(length))
+}
+
+// NewReaderAt reads the segment table from the TDF manifest, then has the C SDK
+// decrypt one segment's range at a time as it is read. The manifest is read on the
+// Go side, so this does not work with S3 storage.
+func (tdfsdk *tdfCInterop) NewReaderAt(ctx context.Context, data *TDFStorage, cacheSegments int) (*TDFReaderAt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	reader, err := openTDF(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := reader.Segments()
+	if err != nil {
+		return nil, translateTDF3Error("read TDF", err)
+	}
+	decrypt := func(seg tdf3.SegmentInfo) ([]byte, error) {
+		//Reads outlive the call that opened the reader, so they don't use its ctx
+		return tdfsdk.DecryptRange(context.Background(), data, seg.PlaintextOffset, seg.PlaintextSize)
+	}
+	return newTDFReaderAt(segments, cacheSegments, decrypt, nil), nil
+}
+
+func (tdfsdk *tdfCInterop) DecryptTDFPartialContext(ctx context.Context, data *TDFStorage, offset, length uint32) (string, error) {
+	plaintext, err := tdfsdk.DecryptPartialBytes(ctx, data, offset, length)
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(plaintext)
+	return string(plaintext), nil
+}
+
+// DecryptStream decrypts the TDF and returns a reader over the plaintext. The C SDK
+// cannot stream, so the whole plaintext is decrypted up front and held in memory
+// until the reader is closed. The reader also implements io.Seeker and io.ReaderAt.
+func (tdfsdk *tdfCInterop) DecryptStream(ctx context.Context, data *TDFStorage) (io.ReadCloser, error) {
+	plaintext, err := tdfsdk.DecryptBytes(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &plaintextReader{Reader: bytes.NewReader(plaintext), plaintext: plaintext}, nil
+}
+
 func (tdfsdk *tdfCInterop) GetEncryptedMetadata(data *TDFStorage) (string, error) {
 	return tdfsdk.getEncryptedMetadataFromTDF(data)
 }
 
+func (tdfsdk *tdfCInterop) GetPolicyFromTDFContext(ctx context.Context, data *TDFStorage) (*TDFPolicy, error) {
+	return runWithContext(tdfsdk, ctx, data, func() (*TDFPolicy, error) {
+		return tdfsdk.GetPolicyFromTDF(data)
+	}, nil)
+}
+
 func (tdfsdk *tdfCInterop) GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error) {
 	var tdfPolicy TDFPolicy
 	policyJSON, err := tdfsdk.getPolicyStringFromTDF(data)
@@ -229,8 +537,8 @@ func (tdfsdk *tdfCInterop) GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error
 	}
 	err = json.Unmarshal([]byte(policyJSON), &tdfPolicy)
 	if err != nil {
-		tdfsdk.logger.Errorf("Error parsing policy JSON string obtained from TDF file! Policy string was %s and error was %s", policyJSON, err)
-		return nil, err
+		tdfsdk.logger.Errorw("Error parsing policy JSON string obtained from TDF file!", LogKeyPolicy, policyJSON, "error", err)
+		return nil, &TDFError{Kind: ErrPolicy, Op: "TDFGetPolicy", Err: err}
 	}
 
 	return &tdfPolicy, nil
@@ -240,10 +548,27 @@ func (tdfsdk *tdfCInterop) GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error
 // and a policy object, and encrypts the string + metadata with the policy, writing the result to the provided
 // output filename.
 func (tdfsdk *tdfCInterop) EncryptToFile(data *TDFStorage, outFile, metadata string, dataAttribs []string) error {
-	return tdfsdk.encryptToFile(data, outFile, tdfsdk.kasURL, metadata, dataAttribs)
+	return tdfsdk.encryptToFile(data, outFile, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+// Note that if ctx is done before the C SDK returns, the output file may still be written afterwards.
+func (tdfsdk *tdfCInterop) EncryptToFileContext(ctx context.Context, data *TDFStorage, outFile, metadata string, dataAttribs []string) error {
+	return tdfsdk.EncryptToFileWithOptions(ctx, data, outFile, EncryptOptions{Metadata: metadata, DataAttributes: dataAttribs})
+}
+
+// Note that if ctx is done before the C SDK returns, the output file may still be written afterwards.
+func (tdfsdk *tdfCInterop) EncryptToFileWithOptions(ctx context.Context, data *TDFStorage, outFile string, opts EncryptOptions) error {
+	_, err := runWithContext(tdfsdk, ctx, data, func() (struct{}, error) {
+		return struct{}{}, tdfsdk.encryptToFile(data, outFile, opts)
+	}, nil)
+	return err
 }
 
 func (tdfsdk *tdfCInterop) GetStorageTypeDescriptor(data *TDFStorage) (string, error) {
+	//Don't read a Go reader into C memory just to describe it
+	if data.load != nil {
+		return data.source.descriptor(), nil
+	}
 	return tdfsdk.getStorageTypeDescriptor(data)
 }
 
@@ -253,28 +578,27 @@ func (tdfsdk *tdfCInterop) initializeOIDCClient(
 	clientId *C.char,
 	clientSecret *C.char,
 	oidcURL *C.char,
-	kasURL *C.char) {
-
-	tdfsdk.credsPtr = C.TDFCreateCredentialClientCreds(oidcURL, clientId, clientSecret, orgName)
-	if tdfsdk.credsPtr == nil {
-		tdfsdk.logger.Fatal("Could not initialize TDF C SDK credential object!")
-	}
-
-	tdfsdk.logger.Info("Initializing TDF C SDK")
-	tdfsdk.sdkPtr = C.TDFCreateClient(tdfsdk.credsPtr, kasURL)
-	if tdfsdk.sdkPtr == nil {
-		tdfsdk.logger.Fatal("Could not initialize TDF C SDK!")
-	}
+	kasURL *C.char) error {
 
+	//Record these up front, so Close() frees them even if initialization fails
 	tdfsdk.cStringPointersToFree = append(tdfsdk.cStringPointersToFree,
 		email,
 		orgName,
 		clientId,
-		clientSecret,
 		oidcURL,
 		kasURL)
+	//client-cpp copies its arguments into the credential object, so the secret is
+	//wiped as soon as that exists rather than lingering until Close
+	defer freeCString(clientSecret)
 
-	tdfsdk.logger.Debug("TDF C SDK initialized")
+	tdfsdk.credsPtr = C.TDFCreateCredentialClientCreds(oidcURL, clientId, clientSecret, orgName)
+	cAllocs.track(unsafe.Pointer(tdfsdk.credsPtr), "credential object")
+	if tdfsdk.credsPtr == nil {
+		tdfsdk.logger.Error("Could not initialize TDF C SDK credential object!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateCredentialClientCreds"}
+	}
+
+	return tdfsdk.createClient(kasURL)
 }
 
 func (tdfsdk *tdfCInterop) initializeOIDCClientTokenExchange(
@@ -284,135 +608,325 @@ func (tdfsdk *tdfCInterop) initializeOIDCClientTokenExchange(
 	clientSecret *C.char,
 	externalAccessToken *C.char,
 	oidcURL *C.char,
-	kasURL *C.char) {
+	kasURL *C.char) error {
+
+	//Record these up front, so Close() frees them even if initialization fails
+	tdfsdk.cStringPointersToFree = append(tdfsdk.cStringPointersToFree,
+		email,
+		orgName,
+		clientId,
+		oidcURL,
+		kasURL)
+	//client-cpp copies its arguments into the credential object, so the secrets are
+	//wiped as soon as that exists rather than lingering until Close
+	defer freeCString(clientSecret)
+	defer freeCString(externalAccessToken)
 
 	tdfsdk.credsPtr = C.TDFCreateCredentialTokenExchange(oidcURL, clientId, clientSecret, externalAccessToken, orgName)
+	cAllocs.track(unsafe.Pointer(tdfsdk.credsPtr), "credential object")
 	if tdfsdk.credsPtr == nil {
-		tdfsdk.logger.Fatal("Could not initialize TDF C SDK credential object!")
+		tdfsdk.logger.Error("Could not initialize TDF C SDK credential object!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateCredentialTokenExchange"}
 	}
 
-	tdfsdk.logger.Info("Initializing TDF C SDK")
-	tdfsdk.sdkPtr = C.TDFCreateClient(tdfsdk.credsPtr, kasURL)
-	if tdfsdk.sdkPtr == nil {
-		tdfsdk.logger.Fatal("Could not initialize TDF C SDK!")
-	}
+	return tdfsdk.createClient(kasURL)
+}
 
+func (tdfsdk *tdfCInterop) initializeOIDCClientPKI(
+	email *C.char,
+	orgName *C.char,
+	clientId *C.char,
+	certFile *C.char,
+	keyFile *C.char,
+	caFile *C.char,
+	oidcURL *C.char,
+	kasURL *C.char) error {
+
+	//Record these up front, so Close() frees them even if initialization fails
 	tdfsdk.cStringPointersToFree = append(tdfsdk.cStringPointersToFree,
 		email,
 		orgName,
 		clientId,
-		clientSecret,
-		externalAccessToken,
+		certFile,
+		keyFile,
+		caFile,
 		oidcURL,
 		kasURL)
 
+	tdfsdk.credsPtr = C.TDFCreateCredentialPKI(oidcURL, clientId, keyFile, certFile, caFile, orgName)
+	cAllocs.track(unsafe.Pointer(tdfsdk.credsPtr), "credential object")
+	if tdfsdk.credsPtr == nil {
+		tdfsdk.logger.Error("Could not initialize TDF C SDK credential object!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateCredentialPKI"}
+	}
+
+	return tdfsdk.createClient(kasURL)
+}
+
+func (tdfsdk *tdfCInterop) createClient(kasURL *C.char) error {
+	tdfsdk.logger.Info("Initializing TDF C SDK")
+	tdfsdk.sdkPtr = C.TDFCreateClient(tdfsdk.credsPtr, kasURL)
+	cAllocs.track(unsafe.Pointer(tdfsdk.sdkPtr), "client object")
+	if tdfsdk.sdkPtr == nil {
+		tdfsdk.logger.Error("Could not initialize TDF C SDK!")
+		return &TDFError{Kind: ErrFailure, Op: "TDFCreateClient"}
+	}
+
 	tdfsdk.logger.Debug("TDF C SDK initialized")
+	return nil
 }
 
-func (tdfsdk *tdfCInterop) encryptToFile(data *TDFStorage, outFilename, kasURL, metadata string, dataAttribs []string) error {
-	outFile := C.CString(outFilename)
-	defer C.free(unsafe.Pointer(outFile))
+func (tdfsdk *tdfCInterop) encryptToFile(data *TDFStorage, outFilename string, opts EncryptOptions) error {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return err
+	}
+	//Keep data's finalizer from freeing storagePtr while the C SDK uses it
+	defer runtime.KeepAlive(data)
+	outFile := cString(outFilename)
+	defer freeCString(outFile)
 
-	thingsToFree := tdfsdk.addDataAttributes(dataAttribs, kasURL)
-	//Free up resources created in above func after encrypt happens
-	defer func() {
-		for _, f := range thingsToFree {
-			f()
+	return tdfsdk.withEncryptClient(opts, func(sdkPtr C.TDFClientPtr) error {
+		err := tdfsdk.checkTDFStatus(C.TDFEncryptFile(sdkPtr, storagePtr, outFile), "TDFEncryptFile")
+		if err != nil {
+			tdfsdk.logger.Errorf("Error encrypting file!")
+			return err
+		}
+		return nil
+	})
+}
+
+func (tdfsdk *tdfCInterop) encryptToString(data *TDFStorage, opts EncryptOptions) ([]byte, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(data)
+	var strBuf []byte
+	err = tdfsdk.withEncryptClient(opts, func(sdkPtr C.TDFClientPtr) error {
+		var outPtr C.TDFBytesPtr
+		var outSize C.TDFBytesLength
+		err := tdfsdk.checkTDFStatus(C.TDFEncryptString(sdkPtr, storagePtr, &outPtr, &outSize), "TDFEncryptString")
+		if err != nil {
+			tdfsdk.logger.Errorf("Error encrypting string! Error was %s", err)
+			return err
 		}
-	}()
+
+		outLen := C.int(C.uint(outSize))
+		strBuf = C.GoBytes(unsafe.Pointer(outPtr), outLen)
+		//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+		//free the C memspace here
+		freeCBytes(outPtr, outSize)
+		tdfsdk.logger.Debugf("Got TDF with length %d", C.int(outLen))
+		return nil
+	})
+	return strBuf, err
+}
+
+// withEncryptClient runs encrypt against a C client of its own. client-cpp keeps data
+// attributes and metadata on the client object and has no way to clear them, so
+// encrypting with the shared client would leak them into every later call - and into
+// concurrent calls from other goroutines.
+// Creating a client costs about as much as the encrypt itself for small data, so
+// clients without metadata are checked out of, and returned to, a set of idle clients
+// keyed by their KAS URL and attributes. Clients with metadata are never reused, so
+// it doesn't outlive the call.
+func (tdfsdk *tdfCInterop) withEncryptClient(opts EncryptOptions, encrypt func(sdkPtr C.TDFClientPtr) error) error {
+	tdfsdk.closeMu.RLock()
+	defer tdfsdk.closeMu.RUnlock()
+	if tdfsdk.credsPtr == nil {
+		return errClientClosed
+	}
+
+	kasURL := opts.KASURL
+	if kasURL == "" {
+		kasURL = tdfsdk.kasURL
+	}
+	client, err := tdfsdk.getEncryptClient(kasURL, opts.DataAttributes)
+	if err != nil {
+		return err
+	}
 
 	//Only bother to set metadata if we have any to set.
-	if metadata != "" {
-		inMetadata := []byte(metadata)
+	if opts.Metadata != "" {
+		defer client.destroy()
+		inMetadata := []byte(opts.Metadata)
 		inMetSize, inMetPtr := convertGoBufToCBuf(inMetadata)
-		err := tdfsdk.checkTDFStatus(C.TDFSetEncryptedMetadata(tdfsdk.sdkPtr, inMetPtr, (C.uint)(inMetSize)), "TDFSetEncryptedMetadata")
+		err := tdfsdk.checkTDFStatus(C.TDFSetEncryptedMetadata(client.sdkPtr, inMetPtr, (C.uint)(inMetSize)), "TDFSetEncryptedMetadata")
 		if err != nil {
 			tdfsdk.logger.Errorf("Error setting encrypted metadata string before encrypt! Error was %s", err)
 			return err
 		}
+		return encrypt(client.sdkPtr)
 	}
 
-	err := tdfsdk.checkTDFStatus(C.TDFEncryptFile(tdfsdk.sdkPtr, data.storagePtr, outFile), "TDFEncryptFile")
-	if err != nil {
-		tdfsdk.logger.Errorf("Error encrypting file!")
+	if err := encrypt(client.sdkPtr); err != nil {
+		//Don't trust a client the C SDK failed on with later calls
+		client.destroy()
 		return err
 	}
-
+	tdfsdk.putEncryptClient(client)
 	return nil
 }
 
-func (tdfsdk *tdfCInterop) encryptToString(data *TDFStorage, kasURL, metadata string, dataAttribs []string) ([]byte, error) {
-	thingsToFree := tdfsdk.addDataAttributes(dataAttribs, kasURL)
-	//Free up resources created in above func after encrypt happens
-	defer func() {
-		for _, f := range thingsToFree {
-			f()
+// maxIdleEncryptClients bounds the C clients kept for reuse, across all encrypt settings.
+const maxIdleEncryptClients = 8
+
+// encryptClient is a C client set up for one KAS URL and set of data attributes,
+// with the C strings it was set up with.
+type encryptClient struct {
+	sdkPtr   C.TDFClientPtr
+	settings string
+	cStrings []*C.char
+}
+
+func encryptClientSettings(kasURL string, dataAttrs []string) string {
+	return kasURL + "\x00" + strings.Join(dataAttrs, "\x00")
+}
+
+// getEncryptClient checks out an idle client with these settings, or creates one.
+// Callers must hold closeMu.
+func (tdfsdk *tdfCInterop) getEncryptClient(kasURL string, dataAttrs []string) (*encryptClient, error) {
+	settings := encryptClientSettings(kasURL, dataAttrs)
+	tdfsdk.idleMu.Lock()
+	for i, client := range tdfsdk.idleEncryptClients {
+		if client.settings == settings {
+			tdfsdk.idleEncryptClients = append(tdfsdk.idleEncryptClients[:i], tdfsdk.idleEncryptClients[i+1:]...)
+			tdfsdk.idleMu.Unlock()
+			return client, nil
 		}
-	}()
+	}
+	tdfsdk.idleMu.Unlock()
+
+	kasEndpoint := cString(kasURL)
+	//The credentials object is shared, so don't let the C SDK read it while another call uses it
+	tdfsdk.sdkMu.Lock()
+	sdkPtr := C.TDFCreateClient(tdfsdk.credsPtr, kasEndpoint)
+	tdfsdk.sdkMu.Unlock()
+	if sdkPtr == nil {
+		freeCString(kasEndpoint)
+		tdfsdk.logger.Error("Could not initialize TDF C SDK!")
+		return nil, &TDFError{Kind: ErrFailure, Op: "TDFCreateClient"}
+	}
+	cAllocs.track(unsafe.Pointer(sdkPtr), "encrypt client object")
+	client := &encryptClient{sdkPtr: sdkPtr, settings: settings, cStrings: []*C.char{kasEndpoint}}
+	tdfsdk.enableDebugLogging(sdkPtr)
 
-	//Only bother to set metadata if we have any to set.
-	if metadata != "" {
-		inMetadata := []byte(metadata)
-		inMetSize, inMetPtr := convertGoBufToCBuf(inMetadata)
-		err := tdfsdk.checkTDFStatus(C.TDFSetEncryptedMetadata(tdfsdk.sdkPtr, inMetPtr, (C.uint)(inMetSize)), "TDFSetEncryptedMetadata")
-		if err != nil {
-			tdfsdk.logger.Errorf("Error setting encrypted metadata string before encrypt! Error was %s", err)
+	for _, dataAttr := range dataAttrs {
+		attr := cString(dataAttr)
+		client.cStrings = append(client.cStrings, attr)
+		if err := tdfsdk.checkTDFStatus(C.TDFAddDataAttribute(sdkPtr, attr, kasEndpoint), "TDFAddDataAttribute"); err != nil {
+			tdfsdk.logger.Errorf("Error adding data attributes before encrypt! Error was %s", err)
+			client.destroy()
 			return nil, err
 		}
 	}
+	return client, nil
+}
 
-	var outPtr C.TDFBytesPtr
-	var outSize C.TDFBytesLength
-	err := tdfsdk.checkTDFStatus(C.TDFEncryptString(tdfsdk.sdkPtr, data.storagePtr, &outPtr, &outSize), "TDFEncryptString")
-	if err != nil {
-		tdfsdk.logger.Errorf("Error encrypting string! Error was %s", err)
-		return nil, err
+// putEncryptClient keeps client for reuse, or destroys it if enough clients are idle.
+// Callers must hold closeMu.
+func (tdfsdk *tdfCInterop) putEncryptClient(client *encryptClient) {
+	tdfsdk.idleMu.Lock()
+	defer tdfsdk.idleMu.Unlock()
+	if len(tdfsdk.idleEncryptClients) >= maxIdleEncryptClients {
+		//Drop the least recently returned client
+		tdfsdk.idleEncryptClients[0].destroy()
+		tdfsdk.idleEncryptClients = tdfsdk.idleEncryptClients[1:]
 	}
-
-	outLen := C.int(C.uint(outSize))
-	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
-	tdfsdk.logger.Debugf("Got buffer %s with length %d", string(strBuf), C.int(outLen))
-	return strBuf, nil
+	tdfsdk.idleEncryptClients = append(tdfsdk.idleEncryptClients, client)
 }
 
-func (tdfsdk *tdfCInterop) addDataAttributes(dataAttrs []string, kasURL string) []func() {
-	kasEndpoint := C.CString(kasURL)
-	defer C.free(unsafe.Pointer(kasEndpoint))
+// destroyIdleEncryptClients destroys every idle client, which were all created from
+// the current credentials. Callers must hold closeMu for writing.
+func (tdfsdk *tdfCInterop) destroyIdleEncryptClients() {
+	tdfsdk.idleMu.Lock()
+	defer tdfsdk.idleMu.Unlock()
+	for _, client := range tdfsdk.idleEncryptClients {
+		client.destroy()
+	}
+	tdfsdk.idleEncryptClients = nil
+}
 
-	var thingsToFree []func()
-	for _, dataAttr := range dataAttrs {
-		attr := C.CString(dataAttr)
-		thingsToFree = append(thingsToFree, func() { C.free(unsafe.Pointer(attr)) })
-		tdfsdk.checkTDFStatus(C.TDFAddDataAttribute(tdfsdk.sdkPtr, attr, kasEndpoint), "TDFAddDataAttribute")
+func (client *encryptClient) destroy() {
+	cAllocs.untrack(unsafe.Pointer(client.sdkPtr))
+	C.TDFDestroyClient(client.sdkPtr)
+	for _, cstr := range client.cStrings {
+		freeCString(cstr)
 	}
+	client.cStrings = nil
+}
 
-	return thingsToFree
+// lockSharedClient must be held around every call on the shared sdkPtr.
+func (tdfsdk *tdfCInterop) lockSharedClient() (unlock func(), err error) {
+	tdfsdk.closeMu.RLock()
+	if tdfsdk.sdkPtr == nil {
+		tdfsdk.closeMu.RUnlock()
+		return nil, errClientClosed
+	}
+	tdfsdk.sdkMu.Lock()
+	return func() {
+		tdfsdk.sdkMu.Unlock()
+		tdfsdk.closeMu.RUnlock()
+	}, nil
 }
 
 func (tdfsdk *tdfCInterop) decryptBytes(data *TDFStorage) (string, error) {
+	strBuf, err := tdfsdk.decryptToGoBytes(data)
+	if err != nil {
+		return "", err
+	}
+	decStr := string(strBuf)
+	wipeBytes(strBuf)
+	tdfsdk.logger.Debugf("Got plaintext with length %d", len(decStr))
+	return decStr, nil
+}
+
+func (tdfsdk *tdfCInterop) decryptToGoBytes(data *TDFStorage) ([]byte, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(data)
 	var outPtr C.TDFBytesPtr
 	var outSize C.TDFBytesLength
-	err := tdfsdk.checkTDFStatus(C.TDFDecryptString(tdfsdk.sdkPtr, data.storagePtr, &outPtr, &outSize),
+	unlock, err := tdfsdk.lockSharedClient()
+	if err != nil {
+		return nil, err
+	}
+	err = tdfsdk.checkTDFStatus(C.TDFDecryptString(tdfsdk.sdkPtr, storagePtr, &outPtr, &outSize),
 		"TDFDecryptString")
+	unlock()
 	if err != nil {
 		tdfsdk.logger.Errorf("Error decrypting bytes!, error was %s", err)
-		return "", err
+		return nil, err
 	}
 
 	outLen := C.int(C.uint(outSize))
 	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
+	//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+	//free the C memspace here
+	freeCBytes(outPtr, outSize)
+	return strBuf, nil
+}
+
+func (tdfsdk *tdfCInterop) decryptPartialBytes(data *TDFStorage, offset, length uint32) (string, error) {
+	strBuf, err := tdfsdk.decryptPartialToGoBytes(data, offset, length)
+	if err != nil {
+		return "", err
+	}
 	decStr := string(strBuf)
-	tdfsdk.logger.Debugf("Got buffer %s with length %d", decStr, C.int(outLen))
+	wipeBytes(strBuf)
+	tdfsdk.logger.Debugf("Got plaintext with length %d", len(decStr))
 	return decStr, nil
 }
 
-func (tdfsdk *tdfCInterop) decryptPartialBytes(data *TDFStorage, offset, length uint32) (string, error) {
+func (tdfsdk *tdfCInterop) decryptPartialToGoBytes(data *TDFStorage, offset, length uint32) ([]byte, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(data)
 	var outPtr C.TDFBytesPtr
 	var outSize C.TDFBytesLength
 	var offsetC C.TDFBytesLength
@@ -421,29 +935,42 @@ func (tdfsdk *tdfCInterop) decryptPartialBytes(data *TDFStorage, offset, length
 	offsetC = C.uint(offset)
 	lengthC = C.uint(length)
 
-	err := tdfsdk.checkTDFStatus(C.TDFDecryptDataPartial(tdfsdk.sdkPtr, data.storagePtr, offsetC, lengthC, &outPtr, &outSize),
+	unlock, err := tdfsdk.lockSharedClient()
+	if err != nil {
+		return nil, err
+	}
+	err = tdfsdk.checkTDFStatus(C.TDFDecryptDataPartial(tdfsdk.sdkPtr, storagePtr, offsetC, lengthC, &outPtr, &outSize),
 		"TDFDecryptDataPartial")
+	unlock()
 	if err != nil {
 		tdfsdk.logger.Errorf("Error decrypting partial bytes!, error was %s", err)
-		return "", err
+		return nil, err
 	}
 
 	outLen := C.int(C.uint(outSize))
 	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
-	decStr := string(strBuf)
-	tdfsdk.logger.Debugf("Got buffer %s with length %d", decStr, C.int(outLen))
-	return decStr, nil
+	//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+	//free the C memspace here
+	freeCBytes(outPtr, outSize)
+	return strBuf, nil
 }
 
 func (tdfsdk *tdfCInterop) getEncryptedMetadataFromTDF(data *TDFStorage) (string, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(data)
 	var outPtr C.TDFBytesPtr
 	var outSize C.TDFBytesLength
 
-	err := tdfsdk.checkTDFStatus(C.TDFGetEncryptedMetadata(tdfsdk.sdkPtr, data.storagePtr, &outPtr, &outSize),
+	unlock, err := tdfsdk.lockSharedClient()
+	if err != nil {
+		return "", err
+	}
+	err = tdfsdk.checkTDFStatus(C.TDFGetEncryptedMetadata(tdfsdk.sdkPtr, storagePtr, &outPtr, &outSize),
 		"TDFGetPolicy")
+	unlock()
 	if err != nil {
 		tdfsdk.logger.Errorf("Error getting policy string from TDF bytes!, error was %s", err)
 		return "", err
@@ -451,21 +978,32 @@ func (tdfsdk *tdfCInterop) getEncryptedMetadataFromTDF(data *TDFStorage) (string
 
 	outLen := C.int(C.uint(outSize))
 	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
+	//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+	//free the C memspace here
+	freeCBytes(outPtr, outSize)
 	decStr := string(strBuf)
-	tdfsdk.logger.Debugf("Got encrypted metadata string buffer %s with length %d", decStr, C.int(outLen))
+	wipeBytes(strBuf)
+	tdfsdk.logger.Debugf("Got encrypted metadata with length %d", C.int(outLen))
 	return decStr, nil
 }
 
 func (tdfsdk *tdfCInterop) getPolicyStringFromTDF(data *TDFStorage) (string, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(data)
 
 	var outPtr C.TDFBytesPtr
 	var outSize C.TDFBytesLength
 
-	err := tdfsdk.checkTDFStatus(C.TDFGetPolicy(tdfsdk.sdkPtr, data.storagePtr, &outPtr, &outSize),
+	unlock, err := tdfsdk.lockSharedClient()
+	if err != nil {
+		return "", err
+	}
+	err = tdfsdk.checkTDFStatus(C.TDFGetPolicy(tdfsdk.sdkPtr, storagePtr, &outPtr, &outSize),
 		"TDFGetPolicy")
+	unlock()
 	if err != nil {
 		tdfsdk.logger.Errorf("Error getting policy string from TDF bytes!, error was %s", err)
 		return "", err
@@ -473,20 +1011,25 @@ func (tdfsdk *tdfCInterop) getPolicyStringFromTDF(data *TDFStorage) (string, err
 
 	outLen := C.int(C.uint(outSize))
 	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
+	//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+	//free the C memspace here
+	freeCBytes(outPtr, outSize)
 	decStr := string(strBuf)
-	tdfsdk.logger.Debugf("Got policy string buffer %s with length %d", decStr, C.int(outLen))
+	tdfsdk.logger.Debugf("Got policy string with length %d", C.int(outLen))
 	return decStr, nil
 }
 
 func (tdfsdk *tdfCInterop) getStorageTypeDescriptor(data *TDFStorage) (string, error) {
+	storagePtr, err := data.ptr()
+	if err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(data)
 
 	var outPtr C.TDFBytesPtr
 	var outSize C.TDFBytesLength
 
-	err := tdfsdk.checkTDFStatus(C.TDFGetTDFStorageDescriptor(data.storagePtr, &outPtr, &outSize),
+	err = tdfsdk.checkTDFStatus(C.TDFGetTDFStorageDescriptor(storagePtr, &outPtr, &outSize),
 		"TDFGetTDFStorageDescriptor")
 	if err != nil {
 		tdfsdk.logger.Errorf("Error getting storage descriptor string! Error was %s", err)
@@ -495,14 +1038,74 @@ func (tdfsdk *tdfCInterop) getStorageTypeDescriptor(data *TDFStorage) (string, e
 
 	outLen := C.int(C.uint(outSize))
 	strBuf := C.GoBytes(unsafe.Pointer(outPtr), outLen)
-	//GoBytes copies data from C memspace to Go memspace, so we're free to free the
-	//C memspace here
-	C.free(unsafe.Pointer(outPtr))
+	//GoBytes copies data from C memspace to Go memspace, so we're free to wipe and
+	//free the C memspace here
+	freeCBytes(outPtr, outSize)
 	decStr := string(strBuf)
 	tdfsdk.logger.Debugf("Got storage descriptor string buffer %s with length %d", decStr, C.int(outLen))
 	return decStr, nil
 }
 
+// plaintextReader serves fully decrypted plaintext, wiping it on Close.
+type plaintextReader struct {
+	*bytes.Reader
+	plaintext []byte
+}
+
+func (pr *plaintextReader) Close() error {
+	wipeBytes(pr.plaintext)
+	pr.Reader.Reset(nil)
+	return nil
+}
+
+// runWithContext runs a blocking C SDK call on its own goroutine, so the caller
+// can stop waiting for it (e.g. on a hung KAS or OIDC endpoint) once ctx is done.
+// The C call itself cannot be interrupted: it runs to completion in the background,
+// and Close on the client and on the storage wait for it before freeing C memory.
+// The result of a call that finishes after ctx is done goes to discard, if set,
+// e.g. to wipe plaintext nobody is waiting for.
+func runWithContext[T any](tdfsdk *tdfCInterop, ctx context.Context, data *TDFStorage, call func() (T, error), discard func(T)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	//Nothing can cancel this call, so skip the goroutine
+	if ctx.Done() == nil {
+		return call()
+	}
+
+	tdfsdk.inflight.Add(1)
+	data.inflight.Add(1)
+	done := make(chan cCallResult[T], 1)
+	go func() {
+		defer tdfsdk.inflight.Done()
+		defer data.inflight.Done()
+		value, err := call()
+		done <- cCallResult[T]{value: value, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-ctx.Done():
+		tdfsdk.logger.Warnf("Abandoning in-flight TDF C SDK call: %s", ctx.Err())
+		if discard != nil {
+			go func() {
+				if result := <-done; result.err == nil {
+					discard(result.value)
+				}
+			}()
+		}
+		return zero, ctx.Err()
+	}
+}
+
+// cCallResult carries the result of a C SDK call back from runWithContext's goroutine.
+type cCallResult[T any] struct {
+	value T
+	err   error
+}
+
 func convertGoBufToCBuf(buf []byte) (size C.uint, ptr *C.uchar) {
 	var bufptr *byte
 	if cap(buf) > 0 {
@@ -512,13 +1115,29 @@ func convertGoBufToCBuf(buf []byte) (size C.uint, ptr *C.uchar) {
 }
 
 func (tdfsdk *tdfCInterop) checkTDFStatus(status C.TDF_STATUS, cFuncName string) error {
+	return tdfsdk.statusError(int(status), cFuncName)
+}
+
+// statusError is checkTDFStatus for a status already converted to an int.
+func (tdfsdk *tdfCInterop) statusError(status int, cFuncName string) error {
 	if status == C.TDF_STATUS_SUCCESS {
 		return nil
-	} else if status == C.TDF_STATUS_INVALID_PARAMS {
-		return fmt.Errorf("Bad param calling %s", cFuncName)
-	} else if status == C.TDF_STATUS_FAILURE_NETWORK {
-		return fmt.Errorf("Network error calling %s", cFuncName)
-	} else {
-		return fmt.Errorf("Something went horribly wrong calling: %s, got code %d", cFuncName, status)
 	}
+
+	kind := ErrFailure
+	switch status {
+	case C.TDF_STATUS_INVALID_PARAMS:
+		kind = ErrInvalidParams
+	case C.TDF_STATUS_FAILURE_NETWORK:
+		kind = ErrNetwork
+	case C.TDF_STATUS_FAILURE:
+		tdfsdk.expiryMu.Lock()
+		expiry := tdfsdk.externalTokenExpiry
+		tdfsdk.expiryMu.Unlock()
+		if !expiry.IsZero() && time.Now().After(expiry) {
+			//client-cpp can't say why token exchange failed, but this is the likeliest reason
+			kind = ErrTokenExpired
+		}
+	}
+	return &TDFError{Kind: kind, Op: cFuncName, Status: status}
 }
@@ -0,0 +1,214 @@
+//go:build cgo
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Run with -race: the abandoned call's result must only reach discard, never the caller.
+func TestRunWithContextWipesAbandonedResult(t *testing.T) {
+	tdfsdk := &tdfCInterop{logger: zap.NewNop().Sugar()}
+	storage := &TDFStorage{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	plaintext := []byte("plaintext nobody waits for")
+	started, release, wiped := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	got, err := runWithContext(tdfsdk, ctx, storage, func() ([]byte, error) {
+		close(started)
+		<-release
+		return plaintext, nil
+	}, func(late []byte) {
+		wipeBytes(late)
+		close(wiped)
+	})
+	if !errors.Is(err, context.Canceled) || got != nil {
+		t.Fatalf("runWithContext() = %q, %v, want nil, context.Canceled", got, err)
+	}
+
+	close(release)
+	select {
+	case <-wiped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("late result was never discarded")
+	}
+	if !bytes.Equal(plaintext, make([]byte, len(plaintext))) {
+		t.Error("late plaintext was not wiped")
+	}
+	tdfsdk.inflight.Wait()
+	storage.inflight.Wait()
+}
+
+func TestRunWithContextReturnsResult(t *testing.T) {
+	tdfsdk := &tdfCInterop{logger: zap.NewNop().Sugar()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, err := runWithContext(tdfsdk, ctx, &TDFStorage{}, func() (string, error) {
+		return "result", nil
+	}, nil)
+	if got != "result" || err != nil {
+		t.Errorf("runWithContext() = %q, %v, want result, nil", got, err)
+	}
+}
+
+// The TDF_STATUS values from tdf_constants_c.h, which tests can't include
+const (
+	testStatusInvalidParams  = 1
+	testStatusFailure        = 2
+	testStatusFailureNetwork = 4
+)
+
+func TestStatusErrorTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name   string
+		expiry time.Time
+		status int
+		want   error
+	}{
+		{"failure after expiry", time.Now().Add(-time.Minute), testStatusFailure, ErrTokenExpired},
+		{"failure before expiry", time.Now().Add(time.Hour), testStatusFailure, ErrFailure},
+		{"failure without expiry", time.Time{}, testStatusFailure, ErrFailure},
+		{"network error after expiry", time.Now().Add(-time.Minute), testStatusFailureNetwork, ErrNetwork},
+		{"invalid params after expiry", time.Now().Add(-time.Minute), testStatusInvalidParams, ErrInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tdfsdk := &tdfCInterop{logger: zap.NewNop().Sugar(), externalTokenExpiry: tt.expiry}
+			if err := tdfsdk.statusError(tt.status, "TDFDecryptString"); !errors.Is(err, tt.want) {
+				t.Errorf("statusError() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// cppTestsEnv gates the tests that need a working client-cpp to encrypt and decrypt,
+// which not every cgo build links against.
+const cppTestsEnv = "OPENTDF_CPP_TESTS"
+
+func newTestCPPClient(t *testing.T, idp *httptest.Server, kas *testKAS) *tdfCInterop {
+	t.Helper()
+	tdfClient, err := NewTDFClient(WithBackend(BackendCPP), WithClientCredentials("tdf", "tdf-client", "secret"),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	t.Cleanup(tdfClient.Close)
+	return tdfClient.(*tdfCInterop)
+}
+
+func TestEncryptClientReuse(t *testing.T) {
+	tdfsdk := newTestCPPClient(t, newTestIdP(t), newTestKAS(t))
+	tdfsdk.closeMu.RLock()
+	defer tdfsdk.closeMu.RUnlock()
+
+	client, err := tdfsdk.getEncryptClient(tdfsdk.kasURL, []string{"https://example.com/attr/A/value/1"})
+	if err != nil {
+		t.Fatalf("getEncryptClient() error = %v", err)
+	}
+	tdfsdk.putEncryptClient(client)
+	other, err := tdfsdk.getEncryptClient(tdfsdk.kasURL, []string{"https://example.com/attr/A/value/2"})
+	if err != nil {
+		t.Fatalf("getEncryptClient() error = %v", err)
+	}
+	if other == client {
+		t.Error("client for other attributes was reused")
+	}
+	other.destroy()
+	again, err := tdfsdk.getEncryptClient(tdfsdk.kasURL, []string{"https://example.com/attr/A/value/1"})
+	if err != nil {
+		t.Fatalf("getEncryptClient() error = %v", err)
+	}
+	if again != client {
+		t.Error("idle client for the same settings was not reused")
+	}
+
+	for i := 0; i < maxIdleEncryptClients+2; i++ {
+		client, err := tdfsdk.getEncryptClient(fmt.Sprintf("https://kas%d.example.com", i), nil)
+		if err != nil {
+			t.Fatalf("getEncryptClient() error = %v", err)
+		}
+		tdfsdk.putEncryptClient(client)
+	}
+	again.destroy()
+	tdfsdk.idleMu.Lock()
+	defer tdfsdk.idleMu.Unlock()
+	if idle := len(tdfsdk.idleEncryptClients); idle != maxIdleEncryptClients {
+		t.Errorf("%d idle clients, want %d", idle, maxIdleEncryptClients)
+	}
+}
+
+// Run with -race and OPENTDF_CPP_TESTS set: goroutines sharing one client-cpp client
+// must each get their own attributes and metadata, including from reused clients.
+func TestConcurrentEncryptCPP(t *testing.T) {
+	if os.Getenv(cppTestsEnv) == "" {
+		t.Skipf("set %s to run against client-cpp", cppTestsEnv)
+	}
+	idp, kas := newTestIdP(t), newTestKAS(t)
+	tdfsdk := newTestCPPClient(t, idp, kas)
+	ctx := context.Background()
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- encryptAndCheck(ctx, tdfsdk, kas, i)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			errs <- encryptReusedAndCheck(ctx, tdfsdk, kas, i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// encryptReusedAndCheck encrypts without metadata, a few times with settings other
+// workers share, so the idle clients get reused.
+func encryptReusedAndCheck(ctx context.Context, tdfClient TDFClient, kas *testKAS, i int) error {
+	attribute := fmt.Sprintf("https://example.com/attr/Shared/value/%d", i%3)
+	for j := 0; j < 4; j++ {
+		storage, err := NewTDFStorageString(fmt.Sprintf("plaintext %d.%d", i, j))
+		if err != nil {
+			return err
+		}
+		tdf, err := tdfClient.EncryptWithOptions(ctx, storage, EncryptOptions{DataAttributes: []string{attribute}})
+		storage.Close()
+		if err != nil {
+			return fmt.Errorf("worker %d: EncryptWithOptions() error = %v", i, err)
+		}
+		policy, metadata, err := readTestTDF(kas, tdf)
+		if err != nil {
+			return fmt.Errorf("worker %d: reading TDF: %v", i, err)
+		}
+		if attrs := policy.Body.DataAttributes; len(attrs) != 1 || attrs[0].Attribute != attribute {
+			return fmt.Errorf("worker %d: policy attributes = %v, want only %s", i, attrs, attribute)
+		}
+		if metadata != "" {
+			return fmt.Errorf("worker %d: metadata = %q, want none", i, metadata)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// AuthMode selects how a TDFClient authenticates to the OIDC IdP.
+type AuthMode int
+
+const (
+	// OIDC client secret credentials (the client_credentials grant)
+	AuthClientCredentials AuthMode = iota + 1
+	// OIDC token exchange of an externally obtained access token
+	AuthTokenExchange
+	// OIDC client credentials authenticated by an X.509 client certificate over mutual TLS
+	AuthClientCertificate
+	// Access tokens from a caller-supplied oauth2.TokenSource
+	AuthTokenSource
+	// A user login with the OAuth 2.0 device authorization grant
+	AuthDeviceCode
+	// A user login in the browser with the OIDC authorization code flow and PKCE
+	AuthAuthorizationCode
+)
+
+// Backend selects the implementation behind a TDFClient.
+type Backend int
+
+const (
+	// Use the client-cpp library when built with cgo and it supports the auth mode and HTTP
+	// options, the native client otherwise
+	BackendAuto Backend = iota
+	// The cgo wrapper around client-cpp
+	BackendCPP
+	// The pure-Go client
+	BackendNative
+)
+
+// Option configures a TDFClient created by NewTDFClient.
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	backend  Backend
+	authMode AuthMode
+
+	email               string
+	orgName             string
+	clientId            string
+	clientSecret        string
+	externalAccessToken string
+	oidcURL             string
+	kasURL              string
+
+	// Client certificate for AuthClientCertificate, from PEM files or a PKCS#12 file
+	certFile       string
+	keyFile        string
+	caFile         string
+	pkcs12File     string
+	pkcs12Password string
+
+	tokenSource      oauth2.TokenSource
+	boundTokenSource BoundTokenFunc
+
+	devicePrompt   func(DeviceAuthorization)
+	openBrowser    func(authURL string) error
+	tokenCacheFile string
+
+	logger      *zap.Logger
+	logPolicy   LogPolicy
+	httpTimeout time.Duration
+	// Whether WithHTTPTimeout was given, even with the default
+	httpTimeoutSet bool
+	tlsConfig      *tls.Config
+	userAgent      string
+}
+
+// Authenticate with OIDC client secret credentials.
+func WithClientCredentials(orgName, clientId, clientSecret string) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthClientCredentials
+		cfg.orgName, cfg.clientId, cfg.clientSecret = orgName, clientId, clientSecret
+		cfg.externalAccessToken = ""
+	}
+}
+
+// Authenticate by exchanging externalAccessToken for a token issued to clientId.
+func WithTokenExchange(orgName, clientId, clientSecret, externalAccessToken string) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthTokenExchange
+		cfg.orgName, cfg.clientId, cfg.clientSecret = orgName, clientId, clientSecret
+		cfg.externalAccessToken = externalAccessToken
+	}
+}
+
+// The user the client acts on behalf of. Optional.
+func WithUser(email string) Option {
+	return func(cfg *clientConfig) {
+		cfg.email = email
+	}
+}
+
+// Base URL of the OIDC IdP, e.g. "https://keycloak.example.com".
+func WithOIDCURL(oidcURL string) Option {
+	return func(cfg *clientConfig) {
+		cfg.oidcURL = oidcURL
+	}
+}
+
+// URL of the KAS that newly encrypted TDFs are bound to.
+func WithKASURL(kasURL string) Option {
+	return func(cfg *clientConfig) {
+		cfg.kasURL = kasURL
+	}
+}
+
+// Logger for the client. Defaults to a no-op logger.
+func WithLogger(logger *zap.Logger) Option {
+	return func(cfg *clientConfig) {
+		cfg.logger = logger
+	}
+}
+
+// Which sensitive values (payloads, tokens, secrets, policies) the client may log.
+// Defaults to redacting all of them. See LogPolicy.
+func WithLogPolicy(policy LogPolicy) Option {
+	return func(cfg *clientConfig) {
+		cfg.logPolicy = policy
+	}
+}
+
+// Timeout for each HTTP request made to the IdP and KAS. Defaults to 60 seconds.
+// Only supported by the native backend.
+func WithHTTPTimeout(timeout time.Duration) Option {
+	return func(cfg *clientConfig) {
+		cfg.httpTimeout, cfg.httpTimeoutSet = timeout, true
+	}
+}
+
+// TLS configuration for connections to the IdP and KAS, e.g. to trust a private CA.
+// Only supported by the native backend.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(cfg *clientConfig) {
+		cfg.tlsConfig = tlsConfig
+	}
+}
+
+// User-Agent header sent with every HTTP request. Only supported by the native backend.
+func WithUserAgent(userAgent string) Option {
+	return func(cfg *clientConfig) {
+		cfg.userAgent = userAgent
+	}
+}
+
+// Selects the TDFClient implementation. Defaults to BackendAuto.
+func WithBackend(backend Backend) Option {
+	return func(cfg *clientConfig) {
+		cfg.backend = backend
+	}
+}
+
+// NewTDFClient creates a TDFClient configured by opts. An auth mode (WithClientCredentials,
+// WithTokenExchange, WithClientCertificate, WithTokenSource, WithBoundTokenSource,
+// WithDeviceCode or WithAuthorizationCode) and WithKASURL are required,
+// and WithOIDCURL is too unless the auth mode is WithTokenSource or WithBoundTokenSource.
+// Callers must Close() the returned client when they're done with it.
+func NewTDFClient(opts ...Option) (TDFClient, error) {
+	cfg, err := newClientConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.backend == BackendCPP {
+		return newCPPClient(cfg)
+	}
+	return newNativeClient(cfg)
+}
+
+// newClientConfig applies opts, checks them, and resolves BackendAuto to a concrete backend.
+func newClientConfig(opts []Option) (*clientConfig, error) {
+	cfg := clientConfig{httpTimeout: defaultHTTPTimeout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.logger == nil {
+		cfg.logger = zap.NewNop()
+	}
+	cfg.logger = RedactLogger(cfg.logger, cfg.logPolicy)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.backend == BackendAuto {
+		cfg.backend = BackendNative
+		if cppBackendAvailable && cfg.cppSupportsHTTP() == nil && cfg.cppSupportsAuth() == nil {
+			cfg.backend = BackendCPP
+		}
+	}
+
+	switch cfg.backend {
+	case BackendCPP:
+		if err := cfg.cppSupportsHTTP(); err != nil {
+			return nil, err
+		}
+		if err := cfg.cppSupportsAuth(); err != nil {
+			return nil, err
+		}
+		if cfg.authMode == AuthClientCertificate {
+			//client-cpp reads the files itself, but fails far less clearly
+			if _, err := cfg.loadClientCertificate(); err != nil {
+				return nil, err
+			}
+		}
+	case BackendNative:
+		if cfg.authMode == AuthClientCertificate {
+			tlsConfig, err := cfg.clientCertificateTLSConfig()
+			if err != nil {
+				return nil, err
+			}
+			cfg.tlsConfig = tlsConfig
+		}
+	default:
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: errors.New("unknown backend")}
+	}
+	return &cfg, nil
+}
+
+func (cfg *clientConfig) validate() error {
+	var missing string
+	switch {
+	case cfg.authMode == 0:
+		missing = "auth mode"
+	case cfg.oidcURL == "" && cfg.authMode != AuthTokenSource:
+		missing = "OIDC URL"
+	case cfg.kasURL == "":
+		missing = "KAS URL"
+	case cfg.authMode == AuthClientCertificate && !cfg.hasClientCertificate():
+		missing = "client certificate"
+	case cfg.authMode == AuthTokenSource && cfg.tokenSource == nil && cfg.boundTokenSource == nil:
+		missing = "token source"
+	default:
+		return nil
+	}
+	return &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: errors.New("no " + missing + " given")}
+}
+
+// cppSupportsHTTP returns an error if cfg sets HTTP options client-cpp has no way to
+// take, in which case BackendAuto uses the native backend.
+func (cfg *clientConfig) cppSupportsHTTP() error {
+	if cfg.tlsConfig != nil || cfg.userAgent != "" || cfg.httpTimeoutSet {
+		return &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient",
+			Err: errors.New("HTTP timeout, TLS config and user agent are only supported by the native backend")}
+	}
+	return nil
+}
+
+// cppSupportsAuth returns an error if client-cpp can't authenticate the way cfg asks,
+// in which case BackendAuto uses the native backend.
+func (cfg *clientConfig) cppSupportsAuth() error {
+	var unsupported string
+	switch {
+	case cfg.authMode == AuthClientCertificate && cfg.pkcs12File != "":
+		unsupported = "PKCS#12 client certificates are"
+	case cfg.authMode == AuthTokenSource:
+		unsupported = "token sources are"
+	case cfg.authMode == AuthDeviceCode:
+		unsupported = "device code logins are"
+	case cfg.authMode == AuthAuthorizationCode:
+		unsupported = "authorization code logins are"
+	default:
+		return nil
+	}
+	return &TDFError{Kind: ErrInvalidParams, Op: "NewTDFClient", Err: errors.New(unsupported + " only supported by the native backend")}
+}
+
+// newHTTPClient builds the HTTP client the native backend talks to the IdP and KAS with.
+// Its transport is the client's own, so Close only drops the client's idle connections.
+func (cfg *clientConfig) newHTTPClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.tlsConfig != nil {
+		t.TLSClientConfig = cfg.tlsConfig
+	}
+	var transport http.RoundTripper = t
+	if cfg.userAgent != "" {
+		transport = &userAgentTransport{userAgent: cfg.userAgent, next: transport}
+	}
+	return &http.Client{Timeout: cfg.httpTimeout, Transport: transport}
+}
+
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+func (t *userAgentTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackendAutoPicksNativeForHTTPOptions(t *testing.T) {
+	auth := []Option{WithClientCredentials("tdf", "tdf-client", "secret"),
+		WithOIDCURL("https://keycloak.example.com"), WithKASURL("https://kas.example.com")}
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"TLS config", WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})},
+		{"user agent", WithUserAgent("tdf-test/1.0")},
+		{"HTTP timeout", WithHTTPTimeout(5 * time.Second)},
+		// client-cpp's own timeout may differ from the native default
+		{"default HTTP timeout", WithHTTPTimeout(defaultHTTPTimeout)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newClientConfig(append(auth, tt.opt))
+			if err != nil {
+				t.Fatalf("newClientConfig() error = %v", err)
+			}
+			if cfg.backend != BackendNative {
+				t.Errorf("backend = %d, want BackendNative", cfg.backend)
+			}
+
+			_, err = newClientConfig(append(auth, tt.opt, WithBackend(BackendCPP)))
+			if cppBackendAvailable && !errors.Is(err, ErrInvalidParams) {
+				t.Errorf("newClientConfig(BackendCPP) error = %v, want ErrInvalidParams", err)
+			}
+		})
+	}
+
+	cfg, err := newClientConfig(auth)
+	if err != nil {
+		t.Fatalf("newClientConfig() error = %v", err)
+	}
+	if cppBackendAvailable && cfg.backend != BackendCPP {
+		t.Errorf("backend without HTTP options = %d, want BackendCPP", cfg.backend)
+	}
+}
+
+func TestNewHTTPClientOwnsItsTransport(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithUserAgent("tdf-test/1.0")}} {
+		cfg, err := newClientConfig(append([]Option{WithClientCredentials("tdf", "tdf-client", "secret"),
+			WithOIDCURL("https://keycloak.example.com"), WithKASURL("https://kas.example.com")}, opts...))
+		if err != nil {
+			t.Fatalf("newClientConfig() error = %v", err)
+		}
+		transport := cfg.newHTTPClient().Transport
+		if ua, ok := transport.(*userAgentTransport); ok {
+			transport = ua.next
+		}
+		if transport == http.DefaultTransport {
+			t.Error("HTTP client uses http.DefaultTransport, which Close would drop every idle connection of")
+		}
+	}
+}
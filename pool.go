@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Returned (as the Kind of a *TDFError) when no pooled client became free within the pool's maximum wait
+var ErrPoolExhausted = errors.New("no pooled TDF client available")
+
+var errPoolClosed = &TDFError{Kind: ErrInvalidParams, Op: "ClientPool", Err: errors.New("pool is closed")}
+
+// ClientPool hands out a fixed set of TDFClients one operation at a time, for
+// services that need more throughput than one client gives. Pooled native clients
+// share one key pair, OIDC access token and KAS public key cache, so the pool
+// authenticates once rather than once per client. client-cpp keeps its tokens
+// internal, so each pooled client-cpp client authenticates on its own.
+type ClientPool struct {
+	clients []TDFClient
+	idle    chan TDFClient
+	maxWait time.Duration
+
+	// Guards isClosed and adding to active, so no operation starts once Close is waiting
+	mu       sync.Mutex
+	isClosed bool
+	closed   chan struct{}
+	// Operations in progress, waited on by Close
+	active sync.WaitGroup
+
+	inUse      atomic.Int64
+	operations atomic.Int64
+	waits      atomic.Int64
+	timeouts   atomic.Int64
+	waitNanos  atomic.Int64
+}
+
+// PoolStats is a snapshot of a ClientPool's usage.
+type PoolStats struct {
+	// Number of clients in the pool
+	Size int
+	// Clients currently running an operation
+	InUse int
+	// Operations started since the pool was created
+	Operations int64
+	// Operations that had to wait for a client to become free
+	Waits int64
+	// Operations that gave up waiting for a client
+	Timeouts int64
+	// Total time operations spent waiting for a client
+	WaitTime time.Duration
+}
+
+// NewClientPool creates size clients configured by opts (see NewTDFClient).
+// Operations wait at most maxWait for a free client, or until their context
+// is done if maxWait is zero.
+// Callers must Close() the pool when they're done with it.
+func NewClientPool(size int, maxWait time.Duration, opts ...Option) (*ClientPool, error) {
+	if size < 1 {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewClientPool", Err: errors.New("pool size must be at least 1")}
+	}
+	cfg, err := newClientConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &ClientPool{
+		idle:    make(chan TDFClient, size),
+		maxWait: maxWait,
+		closed:  make(chan struct{}),
+	}
+
+	var first *tdfNative
+	for i := 0; i < size; i++ {
+		var tdfClient TDFClient
+		switch {
+		case cfg.backend == BackendCPP:
+			tdfClient, err = newCPPClient(cfg)
+		case first == nil:
+			first, err = newTDFNative(cfg)
+			tdfClient = first
+		default:
+			tdfClient = first.sharedClone()
+		}
+		if err != nil {
+			for _, c := range pool.clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		pool.clients = append(pool.clients, tdfClient)
+		pool.idle <- tdfClient
+	}
+	return pool, nil
+}
+
+// Do runs op with a client from the pool, waiting for one to become free if they
+// are all in use. The client must not be used after op returns.
+func (pool *ClientPool) Do(ctx context.Context, op func(TDFClient) error) error {
+	tdfClient, err := pool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.release(tdfClient)
+	return op(tdfClient)
+}
+
+func (pool *ClientPool) acquire(ctx context.Context) (TDFClient, error) {
+	select {
+	case <-pool.closed:
+		return nil, errPoolClosed
+	default:
+	}
+	pool.operations.Add(1)
+
+	select {
+	case tdfClient := <-pool.idle:
+		return pool.checkOut(tdfClient)
+	default:
+	}
+
+	pool.waits.Add(1)
+	start := time.Now()
+	defer func() { pool.waitNanos.Add(int64(time.Since(start))) }()
+
+	var timeout <-chan time.Time
+	if pool.maxWait > 0 {
+		timer := time.NewTimer(pool.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case tdfClient := <-pool.idle:
+		return pool.checkOut(tdfClient)
+	case <-timeout:
+		pool.timeouts.Add(1)
+		return nil, &TDFError{Kind: ErrPoolExhausted, Op: "ClientPool"}
+	case <-ctx.Done():
+		pool.timeouts.Add(1)
+		return nil, ctx.Err()
+	case <-pool.closed:
+		return nil, errPoolClosed
+	}
+}
+
+func (pool *ClientPool) checkOut(tdfClient TDFClient) (TDFClient, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.isClosed {
+		pool.idle <- tdfClient
+		return nil, errPoolClosed
+	}
+	pool.active.Add(1)
+	pool.inUse.Add(1)
+	return tdfClient, nil
+}
+
+func (pool *ClientPool) release(tdfClient TDFClient) {
+	pool.inUse.Add(-1)
+	pool.idle <- tdfClient
+	pool.active.Done()
+}
+
+// Stats returns a snapshot of the pool's usage counters.
+func (pool *ClientPool) Stats() PoolStats {
+	return PoolStats{
+		Size:       len(pool.clients),
+		InUse:      int(pool.inUse.Load()),
+		Operations: pool.operations.Load(),
+		Waits:      pool.waits.Load(),
+		Timeouts:   pool.timeouts.Load(),
+		WaitTime:   time.Duration(pool.waitNanos.Load()),
+	}
+}
+
+// SetExternalAccessToken replaces the external access token of every pooled client
+// (see TDFClient.SetExternalAccessToken).
+func (pool *ClientPool) SetExternalAccessToken(externalAccessToken string) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.isClosed {
+		return errPoolClosed
+	}
+	for _, tdfClient := range pool.clients {
+		if err := tdfClient.SetExternalAccessToken(externalAccessToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops handing out clients, waits for operations in progress to finish,
+// and closes every pooled client. It is safe to call more than once.
+func (pool *ClientPool) Close() {
+	pool.mu.Lock()
+	if pool.isClosed {
+		pool.mu.Unlock()
+		return
+	}
+	pool.isClosed = true
+	close(pool.closed)
+	pool.mu.Unlock()
+
+	pool.active.Wait()
+	for _, tdfClient := range pool.clients {
+		tdfClient.Close()
+	}
+}
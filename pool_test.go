@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, size int, maxWait time.Duration, idp *httptest.Server, kas *testKAS) *ClientPool {
+	t.Helper()
+	pool, err := NewClientPool(size, maxWait, WithBackend(BackendNative), WithClientCredentials("tdf", "tdf-client", "secret"),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewClientPool() error = %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// holdClient runs an operation on pool that keeps its client until release is closed,
+// and returns once it has the client.
+func holdClient(t *testing.T, pool *ClientPool, release chan struct{}) <-chan error {
+	t.Helper()
+	held, done := make(chan struct{}), make(chan error, 1)
+	go func() {
+		done <- pool.Do(context.Background(), func(TDFClient) error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	select {
+	case <-held:
+	case <-time.After(10 * time.Second):
+		t.Fatal("operation never got a client")
+	}
+	return done
+}
+
+func TestClientPoolExhausted(t *testing.T) {
+	const maxWait = 50 * time.Millisecond
+	pool := newTestPool(t, 1, maxWait, newTestIdP(t), newTestKAS(t))
+	release := make(chan struct{})
+	done := holdClient(t, pool, release)
+
+	start := time.Now()
+	err := pool.Do(context.Background(), func(TDFClient) error {
+		t.Error("operation ran with every client in use")
+		return nil
+	})
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Do() error = %v, want ErrPoolExhausted", err)
+	}
+	if waited := time.Since(start); waited < maxWait || waited > maxWait+5*time.Second {
+		t.Errorf("Do() gave up after %v, want about %v", waited, maxWait)
+	}
+
+	stats := pool.Stats()
+	if stats.Size != 1 || stats.InUse != 1 || stats.Operations != 2 || stats.Waits != 1 || stats.Timeouts != 1 || stats.WaitTime < maxWait {
+		t.Errorf("Stats() = %+v, want 1 client in use, 2 operations, 1 wait timing out after at least %v", stats, maxWait)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Do() error = %v", err)
+	}
+	if stats := pool.Stats(); stats.InUse != 0 {
+		t.Errorf("Stats().InUse = %d after the operation finished, want 0", stats.InUse)
+	}
+}
+
+func TestClientPoolCloseWaits(t *testing.T) {
+	pool := newTestPool(t, 2, 0, newTestIdP(t), newTestKAS(t))
+	release := make(chan struct{})
+	done := holdClient(t, pool, release)
+
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a client was checked out")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := pool.Do(context.Background(), func(TDFClient) error { return nil }); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("Do() on a closing pool error = %v, want ErrInvalidParams", err)
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close() never returned after the operation finished")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Do() error = %v", err)
+	}
+}
+
+// Run with -race: pooled clones share one access token, so the IdP is only asked once.
+func TestClientPoolSharesToken(t *testing.T) {
+	idpHandler := newTestIdP(t).Config.Handler
+	var tokenRequests int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&tokenRequests, 1)
+		idpHandler.ServeHTTP(w, r)
+	}))
+	defer idp.Close()
+	kas := newTestKAS(t)
+	pool := newTestPool(t, 4, 0, idp, kas)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- pool.Do(context.Background(), func(tdfClient TDFClient) error {
+				return encryptAndCheck(context.Background(), tdfClient, kas, i)
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if tokenRequests := atomic.LoadInt64(&tokenRequests); tokenRequests != 1 {
+		t.Errorf("IdP got %d token requests, want 1", tokenRequests)
+	}
+	if stats := pool.Stats(); stats.Operations != workers {
+		t.Errorf("Stats().Operations = %d, want %d", stats.Operations, workers)
+	}
+}
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Size of the first parts of a multipart upload. S3 requires at least 5 MiB for
+	// every part but the last; TDFs no bigger than one part are sent with a single PUT.
+	s3PartSize = 8 << 20
+	// S3 allows at most s3MaxParts parts, so the part size doubles after every
+	// s3PartsPerSize parts. The 10,000th part is 4 GiB, under the 5 GiB limit,
+	// and all of them add up to more than S3's 5 TiB limit on object size.
+	s3PartsPerSize = 1000
+	s3MaxParts     = 10000
+
+	s3DateFormat  = "20060102T150405Z"
+	s3SigningAlgo = "AWS4-HMAC-SHA256"
+)
+
+// Creates a new S3-based TDF output storage object. s3url names the object to write, either as
+// s3://bucket/key or as an http(s) URL of the object - virtual-hosted or path-style, so an
+// S3-compatible service such as MinIO can be used by pointing the URL at it.
+// Large TDFs are uploaded as a multipart upload while they are encrypted, in parts of 8 MiB
+// that double in size every 1,000 parts. Each part is buffered in memory until it is sent.
+func NewTDFOutputS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion string) (*TDFOutputStorage, error) {
+	objectURL, err := s3ObjectURL(s3url, awsRegion)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFOutputS3", Err: err}
+	}
+	return &TDFOutputStorage{sink: &s3Sink{
+		objectURL:  objectURL,
+		signer:     &s3Signer{accessKeyID: awsAccessKeyID, secretKey: awsSecretKey, region: awsRegion},
+		httpClient: &http.Client{Transport: storageTransport},
+	}}, nil
+}
+
+func s3ObjectURL(s3url, region string) (*url.URL, error) {
+	u, err := url.Parse(s3url)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return nil, errors.New("S3 URL must name a bucket and key")
+		}
+		if region == "" {
+			return nil, errors.New("S3 URL needs a region")
+		}
+		return &url.URL{Scheme: "https", Host: u.Host + ".s3." + region + ".amazonaws.com", Path: u.Path}, nil
+	case "http", "https":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported S3 URL scheme %q", u.Scheme)
+	}
+}
+
+type s3Sink struct {
+	objectURL  *url.URL
+	signer     *s3Signer
+	httpClient *http.Client
+}
+
+func (sink *s3Sink) create(ctx context.Context) (StorageWriter, error) {
+	return &chunkedWriter{uploader: &s3Upload{ctx: ctx, sink: sink}, partSize: s3PartSize, growEvery: s3PartsPerSize}, nil
+}
+
+func (sink *s3Sink) descriptor() string {
+	return "s3:" + sink.objectURL.String()
+}
+
+// s3Upload only starts a multipart upload once the first part fills up, so
+// small TDFs need a single request.
+type s3Upload struct {
+	ctx      context.Context
+	sink     *s3Sink
+	uploadID string
+	parts    []s3CompletedPart
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (up *s3Upload) uploadPart(part []byte) error {
+	if len(up.parts) >= s3MaxParts {
+		return &TDFError{Kind: ErrInvalidParams, Op: "S3 upload part",
+			Err: fmt.Errorf("TDF is too large for an S3 multipart upload of %d parts", s3MaxParts)}
+	}
+	if up.uploadID == "" {
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := up.sink.do(up.ctx, http.MethodPost, url.Values{"uploads": {""}}, nil, &result, nil); err != nil {
+			return err
+		}
+		up.uploadID = result.UploadID
+	}
+
+	partNumber := len(up.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {up.uploadID}}
+	var header http.Header
+	if err := up.sink.do(up.ctx, http.MethodPut, query, part, nil, &header); err != nil {
+		return err
+	}
+	up.parts = append(up.parts, s3CompletedPart{PartNumber: partNumber, ETag: header.Get("ETag")})
+	return nil
+}
+
+func (up *s3Upload) finish(last []byte, parts int) error {
+	if parts == 0 {
+		return up.sink.do(up.ctx, http.MethodPut, nil, last, nil, nil)
+	}
+	if len(last) > 0 {
+		if err := up.uploadPart(last); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: up.parts})
+	if err != nil {
+		return err
+	}
+	return up.sink.do(up.ctx, http.MethodPost, url.Values{"uploadId": {up.uploadID}}, body, nil, nil)
+}
+
+func (up *s3Upload) abort() {
+	if up.uploadID == "" {
+		return
+	}
+	//Best effort, and not tied to ctx, which may be why we're aborting
+	//nolint:errcheck
+	up.sink.do(context.Background(), http.MethodDelete, url.Values{"uploadId": {up.uploadID}}, nil, nil, nil)
+	up.uploadID = ""
+}
+
+// do sends a signed request for the object, decoding an XML response body into
+// result and returning the response headers in header, if they're non-nil.
+func (sink *s3Sink) do(ctx context.Context, method string, query url.Values, body []byte, result interface{}, header *http.Header) error {
+	u := *sink.objectURL
+	u.RawQuery = s3EncodeQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sink.signer.sign(req, body, time.Now())
+
+	op := "S3 " + method
+	resp, err := sink.httpClient.Do(req)
+	if err != nil {
+		return newNetworkError(op, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return newNetworkError(op, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return newHTTPStatusError(op, resp.StatusCode)
+	}
+	//CompleteMultipartUpload can fail after S3 has already sent a 200
+	if bytes.Contains(respBody, []byte("<Error>")) {
+		return &TDFError{Kind: ErrFailure, Op: op, Status: resp.StatusCode, Err: errors.New(string(respBody))}
+	}
+	if header != nil {
+		*header = resp.Header
+	}
+	if result != nil {
+		if err := xml.Unmarshal(respBody, result); err != nil {
+			return &TDFError{Kind: ErrFailure, Op: op, Err: err}
+		}
+	}
+	return nil
+}
+
+// s3Signer signs requests with AWS Signature Version 4.
+type s3Signer struct {
+	accessKeyID string
+	secretKey   string
+	region      string
+}
+
+func (signer *s3Signer) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format(s3DateFormat)
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	//Send the path exactly as signed: Go's own escaping leaves characters such as '+' and '=' alone
+	canonicalURI := s3EncodePath(req.URL.Path)
+	if req.URL.Path != "" {
+		req.URL.RawPath = canonicalURI
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + signer.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{s3SigningAlgo, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+signer.secretKey), date)
+	key = hmacSHA256(key, signer.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgo, signer.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3EncodePath URI-encodes every byte of path except unreserved characters and '/'.
+func s3EncodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return s3URIEncode(path, true)
+}
+
+// s3EncodeQuery builds the canonical query string: keys sorted, everything URI-encoded.
+func s3EncodeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3URIEncode(key, false)+"="+s3URIEncode(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3URIEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
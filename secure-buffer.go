@@ -0,0 +1,52 @@
+package client
+
+import "runtime"
+
+// SecureBuffer holds decrypted plaintext that can be wiped as soon as the caller is
+// done with it, rather than lingering in memory until the garbage collector reuses it.
+// Plaintext returned as a string can never be wiped, so prefer DecryptSecure (or
+// DecryptBytes) for sensitive data. If a SecureBuffer is garbage collected without
+// being wiped, its finalizer wipes it.
+// A SecureBuffer must not be wiped while another goroutine reads it.
+type SecureBuffer struct {
+	data []byte
+}
+
+// NewSecureBuffer takes ownership of data, e.g. the result of DecryptRange, so it
+// is wiped along with the buffer.
+func NewSecureBuffer(data []byte) *SecureBuffer {
+	buf := &SecureBuffer{data: data}
+	runtime.SetFinalizer(buf, (*SecureBuffer).Wipe)
+	return buf
+}
+
+// Bytes returns the plaintext itself, not a copy, so it is wiped by Wipe. Don't keep
+// it, or make copies of it, that are meant to outlive the buffer.
+func (buf *SecureBuffer) Bytes() []byte {
+	return buf.data
+}
+
+// Len returns the length of the plaintext, or zero once it has been wiped.
+func (buf *SecureBuffer) Len() int {
+	return len(buf.data)
+}
+
+// Wipe zeroes the plaintext and empties the buffer. Calling it more than once is safe.
+func (buf *SecureBuffer) Wipe() {
+	wipeBytes(buf.data)
+	buf.data = nil
+	runtime.SetFinalizer(buf, nil)
+}
+
+// Close wipes the buffer, so a SecureBuffer can be released with defer buf.Close().
+func (buf *SecureBuffer) Close() error {
+	buf.Wipe()
+	return nil
+}
+
+// wipeBytes zeroes b, which held plaintext, a key or a secret.
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
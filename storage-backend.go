@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Reads smaller than this are widened to it and cached, so the many small reads
+// made while parsing the zip directory and manifest cost a few requests.
+const backendReadAheadSize = 64 << 10
+
+// StorageBackend is a single object in some store, such as a cloud blob store,
+// that TDFs can be read from and written to. NewTDFStorageBackend and
+// NewTDFOutputBackend adapt one for use as TDF input and output; implement it to
+// plug in a store this package doesn't support.
+// Reads made by a TDFClient call get that call's context, except for the
+// client-cpp backend, which reads the object into memory once and shares it
+// between calls; a backend should still bound how long a stalled request waits.
+type StorageBackend interface {
+	// Open returns a reader over the whole object, for reading it front to back.
+	Open(ctx context.Context) (io.ReadCloser, error)
+	// Size returns the size of the object in bytes.
+	Size(ctx context.Context) (int64, error)
+	// ReadRange returns a reader over length bytes of the object, starting at offset.
+	// The range always lies within the object.
+	ReadRange(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+	// Create starts writing a new version of the object. Backends that can't be
+	// written to return an error.
+	Create(ctx context.Context) (StorageWriter, error)
+	// Descriptor describes the object for logging, e.g. "gs://bucket/object".
+	Descriptor() string
+}
+
+// StorageWriter receives an object as it is written. Exactly one of Commit or
+// Abort is called once writing finishes.
+type StorageWriter interface {
+	io.Writer
+	// Commit makes the written object visible in the store.
+	Commit() error
+	// Abort discards whatever was written, as far as the store allows.
+	Abort()
+}
+
+// Creates a new TDF storage object reading from backend. The native client reads
+// only the parts it needs; the client-cpp backend reads all of it into memory first.
+func NewTDFStorageBackend(backend StorageBackend) (*TDFStorage, error) {
+	if backend == nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFStorageBackend", Err: errors.New("nil backend")}
+	}
+	source := &backendSource{backend: backend}
+	storage := TDFStorage{source: source}
+	storage.initLazy(source.readAll)
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new TDF output storage object writing to backend
+func NewTDFOutputBackend(backend StorageBackend) (*TDFOutputStorage, error) {
+	if backend == nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFOutputBackend", Err: errors.New("nil backend")}
+	}
+	return &TDFOutputStorage{sink: &backendSink{backend: backend}}, nil
+}
+
+type backendSink struct {
+	backend StorageBackend
+}
+
+func (sink *backendSink) create(ctx context.Context) (StorageWriter, error) {
+	return sink.backend.Create(ctx)
+}
+
+func (sink *backendSink) descriptor() string {
+	return sink.backend.Descriptor()
+}
+
+type backendSource struct {
+	backend StorageBackend
+
+	mu    sync.Mutex
+	size  int64
+	sized bool
+	// Shared by the readers of every operation
+	block *readAheadBlock
+	// Readers handed out by stream, closed along with the storage
+	streams []io.Closer
+}
+
+func (src *backendSource) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if !src.sized {
+		size, err := src.backend.Size(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		src.size, src.sized = size, true
+		src.block = &readAheadBlock{}
+	}
+	return &backendReaderAt{ctx: ctx, backend: src.backend, size: src.size, block: src.block}, src.size, nil
+}
+
+// stream reads the object with a single request, rather than a range request per read.
+func (src *backendSource) stream(ctx context.Context) (io.Reader, error) {
+	body, err := src.backend.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	src.mu.Lock()
+	src.streams = append(src.streams, body)
+	src.mu.Unlock()
+	return body, nil
+}
+
+// readAll loads the object for client-cpp, which shares it between operations,
+// so it isn't tied to the context of the one that happened to load it.
+func (src *backendSource) readAll() ([]byte, error) {
+	body, err := src.backend.Open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (src *backendSource) descriptor() string {
+	return src.backend.Descriptor()
+}
+
+func (src *backendSource) close() error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	var err error
+	for _, body := range src.streams {
+		if closeErr := body.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	src.streams = nil
+	return err
+}
+
+// backendReaderAt implements io.ReaderAt with range reads of a backend, made with ctx.
+type backendReaderAt struct {
+	ctx     context.Context
+	backend StorageBackend
+	size    int64
+	block   *readAheadBlock
+}
+
+// readAheadBlock is the most recent read-ahead block of a backend object.
+type readAheadBlock struct {
+	mu   sync.Mutex
+	off  int64
+	data []byte
+}
+
+func (r *backendReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	want := p
+	if int64(len(want)) > r.size-off {
+		want = want[:r.size-off]
+	}
+
+	var n int
+	var err error
+	if len(want) < backendReadAheadSize {
+		n, err = r.readCached(want, off)
+	} else {
+		n, err = r.readRange(want, off)
+	}
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readCached serves p from the read-ahead block, fetching a new one if needed.
+// Blocks are kept within the object, so reads near its end - where the zip
+// directory and manifest are - share one block.
+func (r *backendReaderAt) readCached(p []byte, off int64) (int, error) {
+	r.block.mu.Lock()
+	defer r.block.mu.Unlock()
+
+	end := off + int64(len(p))
+	if off < r.block.off || end > r.block.off+int64(len(r.block.data)) {
+		start := off
+		if start > r.size-backendReadAheadSize {
+			start = r.size - backendReadAheadSize
+		}
+		if start < 0 {
+			start = 0
+		}
+		length := int64(backendReadAheadSize)
+		if length > r.size-start {
+			length = r.size - start
+		}
+		block := make([]byte, length)
+		if _, err := r.readRange(block, start); err != nil {
+			return 0, err
+		}
+		r.block.off, r.block.data = start, block
+	}
+	return copy(p, r.block.data[off-r.block.off:]), nil
+}
+
+func (r *backendReaderAt) readRange(p []byte, off int64) (int, error) {
+	body, err := r.backend.ReadRange(r.ctx, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// doStorageRequest sends req for a blob store backend, turning transport failures
+// and error statuses into TDFErrors. The caller closes the body of the returned response.
+func doStorageRequest(httpClient *http.Client, req *http.Request, op string) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, newNetworkError(op, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+		statusErr := newHTTPStatusError(op, resp.StatusCode).(*TDFError)
+		if len(body) > 0 {
+			statusErr.Err = errors.New(string(body))
+		}
+		return nil, statusErr
+	}
+	return resp, nil
+}
+
+// httpRange formats a Range header value for length bytes from offset.
+func httpRange(offset, length int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// rangeBody returns the body of a response to a range request, skipping to offset
+// if the server ignored the Range header and sent the whole object.
+func rangeBody(resp *http.Response, offset int64) (io.ReadCloser, error) {
+	if resp.StatusCode == http.StatusPartialContent || offset == 0 {
+		return resp.Body, nil
+	}
+	if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+		resp.Body.Close()
+		return nil, newNetworkError("range read", err)
+	}
+	return resp.Body, nil
+}
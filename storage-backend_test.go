@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// checkBackendRoundTrip encrypts plaintext to backend with EncryptTo, then reads it
+// back from backend whole and as a range.
+func checkBackendRoundTrip(t *testing.T, tdfClient TDFClient, backend StorageBackend, plaintext []byte) {
+	t.Helper()
+	ctx := context.Background()
+	out, err := NewTDFOutputBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewTDFStorageBytes(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := tdfClient.EncryptTo(ctx, storage, out, EncryptOptions{}); err != nil {
+		t.Fatalf("EncryptTo() error = %v", err)
+	}
+
+	tdfStorage, err := NewTDFStorageBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdfStorage.Close()
+	decrypted, err := tdfClient.DecryptBytes(ctx, tdfStorage)
+	if err != nil {
+		t.Fatalf("DecryptBytes() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Error("decrypted TDF does not match the plaintext")
+	}
+	offset, length := int64(len(plaintext)/2), int64(100)
+	part, err := tdfClient.DecryptRange(ctx, tdfStorage, offset, length)
+	if err != nil {
+		t.Fatalf("DecryptRange() error = %v", err)
+	}
+	if !bytes.Equal(part, plaintext[offset:offset+length]) {
+		t.Error("decrypted range does not match the plaintext")
+	}
+}
+
+// hungBackend is a store that never answers a read until its context is done.
+type hungBackend struct{}
+
+func (hungBackend) Open(ctx context.Context) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungBackend) Size(ctx context.Context) (int64, error) {
+	return 1 << 20, nil
+}
+
+func (hungBackend) ReadRange(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hungBackend) Create(ctx context.Context) (StorageWriter, error) {
+	return nil, errors.New("read-only")
+}
+
+func (hungBackend) Descriptor() string {
+	return "hung"
+}
+
+func TestBackendReadsUseOperationContext(t *testing.T) {
+	idp, kas := newTestIdP(t), newTestKAS(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context, storage *TDFStorage) error
+	}{
+		{"DecryptBytes", func(ctx context.Context, storage *TDFStorage) error {
+			_, err := tdfClient.DecryptBytes(ctx, storage)
+			return err
+		}},
+		{"GetPolicyFromTDFContext", func(ctx context.Context, storage *TDFStorage) error {
+			_, err := tdfClient.GetPolicyFromTDFContext(ctx, storage)
+			return err
+		}},
+		{"EncryptWithOptions", func(ctx context.Context, storage *TDFStorage) error {
+			_, err := tdfClient.EncryptWithOptions(ctx, storage, EncryptOptions{})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, err := NewTDFStorageBackend(hungBackend{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- tt.call(ctx, storage) }()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("reading a hung backend succeeded")
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("reading a hung backend ignored the context")
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// See https://github.com/opentdf/spec/blob/master/schema/AttributeObject.md
+// {
+// "attribute": "https://example.com/attr/classification/value/topsecret"
+// }
+type TDFAttribute struct {
+	Attribute string `json:"attribute"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/PolicyObject.md
+// {
+// "uuid": "1111-2222-33333-44444-abddef-timestamp",
+//
+//	"body": {
+//	   "dataAttributes": [<Attribute Object>],
+//	   "dissem": ["user-id@domain.com"]
+//	 },
+//
+// "tdf_spec_version:": "x.y.z"
+// }
+type TDFPolicy struct {
+	UUID        string        `json:"uuid"`
+	Body        TDFPolicyBody `json:"body"`
+	SpecVersion string        `json:"tdf_spec_version"`
+}
+
+type TDFPolicyBody struct {
+	DataAttributes    []TDFAttribute `json:"dataAttributes"`
+	DisseminationList []string       `json:"dissem"`
+}
+
+// EncryptOptions holds the per-call settings of an encrypt operation. Nothing set
+// here is remembered by the client, so calls never affect each other.
+type EncryptOptions struct {
+	// Optional, can be empty
+	Metadata       string
+	DataAttributes []string
+	// KAS the TDF is bound to. Optional, defaults to the client's KAS URL
+	KASURL string
+}
+
+// The ...Context variants of the TDFClient methods return ctx.Err() as soon as
+// ctx is cancelled or its deadline passes, abandoning any in-flight OIDC or KAS
+// request. The client-cpp backend cannot interrupt a C call, so there the call
+// finishes in the background and Close waits for it.
+//
+// A TDFClient is safe for concurrent use by multiple goroutines, and every
+// encrypt call is self-contained. Close waits for calls in progress; the client
+// must not be used after it is closed.
+type TDFClient interface {
+	Close()
+	EncryptToFile(data *TDFStorage, outFile, metadata string, dataAttribs []string) error
+	EncryptToFileContext(ctx context.Context, data *TDFStorage, outFile, metadata string, dataAttribs []string) error
+	EncryptToString(data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error)
+	EncryptToStringContext(ctx context.Context, data *TDFStorage, metadata string, dataAttribs []string) ([]byte, error)
+	EncryptToFileWithOptions(ctx context.Context, data *TDFStorage, outFile string, opts EncryptOptions) error
+	EncryptWithOptions(ctx context.Context, data *TDFStorage, opts EncryptOptions) ([]byte, error)
+	EncryptStream(ctx context.Context, r io.Reader, w io.Writer, opts EncryptOptions) error
+	EncryptTo(ctx context.Context, data *TDFStorage, out *TDFOutputStorage, opts EncryptOptions) error
+	GetEncryptedMetadata(data *TDFStorage) (string, error)
+	DecryptTDF(data *TDFStorage) (string, error)
+	DecryptTDFContext(ctx context.Context, data *TDFStorage) (string, error)
+	DecryptTDFPartial(data *TDFStorage, offset, length uint32) (string, error)
+	DecryptTDFPartialContext(ctx context.Context, data *TDFStorage, offset, length uint32) (string, error)
+	DecryptStream(ctx context.Context, data *TDFStorage) (io.ReadCloser, error)
+	DecryptBytes(ctx context.Context, data *TDFStorage) ([]byte, error)
+	DecryptSecure(ctx context.Context, data *TDFStorage) (*SecureBuffer, error)
+	DecryptPartialBytes(ctx context.Context, data *TDFStorage, offset, length uint32) ([]byte, error)
+	DecryptRange(ctx context.Context, data *TDFStorage, offset, length int64) ([]byte, error)
+	// cacheSegments is how many decrypted segments to keep, 0 for a default
+	NewReaderAt(ctx context.Context, data *TDFStorage, cacheSegments int) (*TDFReaderAt, error)
+	GetPolicyFromTDF(data *TDFStorage) (*TDFPolicy, error)
+	GetPolicyFromTDFContext(ctx context.Context, data *TDFStorage) (*TDFPolicy, error)
+	GetStorageTypeDescriptor(data *TDFStorage) (string, error)
+	// SetExternalAccessToken replaces the external access token of a client created
+	// with WithTokenExchange, e.g. after an operation failed with ErrTokenExpired.
+	// Calls in progress finish with the old token.
+	SetExternalAccessToken(externalAccessToken string) error
+}
+
+// Creates a new client-cpp backed TDF client that will use OIDC client secret credentials to authenticate.
+// Exits the process via logger.Fatal if the client cannot be created - long-running callers
+// should use NewTDFClientOIDCWithError or NewTDFClient instead.
+func NewTDFClientOIDC(email string, orgName string, clientId string, clientSecret string, oidcURL string, kasURL string, logger *zap.Logger) TDFClient {
+	tdfClient, err := NewTDFClientOIDCWithError(email, orgName, clientId, clientSecret, oidcURL, kasURL, logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return tdfClient
+}
+
+// Creates a new client-cpp backed TDF client that will use OIDC client secret credentials to authenticate,
+// returning an error if the C SDK credential or client objects cannot be created.
+func NewTDFClientOIDCWithError(email string, orgName string, clientId string, clientSecret string, oidcURL string, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithBackend(BackendCPP), WithUser(email), WithClientCredentials(orgName, clientId, clientSecret),
+		WithOIDCURL(oidcURL), WithKASURL(kasURL), WithLogger(logger))
+}
+
+// Creates a new client-cpp backed TDF client that will use OIDC token exchange credentials to authenticate.
+// Exits the process via logger.Fatal if the client cannot be created - long-running callers
+// should use NewTDFClientOIDCTokenExchangeWithError or NewTDFClient instead.
+func NewTDFClientOIDCTokenExchange(email, orgName, clientId, clientSecret, externalAccessToken, oidcURL, kasURL string, logger *zap.Logger) TDFClient {
+	tdfClient, err := NewTDFClientOIDCTokenExchangeWithError(email, orgName, clientId, clientSecret, externalAccessToken, oidcURL, kasURL, logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	return tdfClient
+}
+
+// Creates a new client-cpp backed TDF client that will use OIDC token exchange credentials to authenticate,
+// returning an error if the C SDK credential or client objects cannot be created.
+func NewTDFClientOIDCTokenExchangeWithError(email, orgName, clientId, clientSecret, externalAccessToken, oidcURL, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithBackend(BackendCPP), WithUser(email), WithTokenExchange(orgName, clientId, clientSecret, externalAccessToken),
+		WithOIDCURL(oidcURL), WithKASURL(kasURL), WithLogger(logger))
+}
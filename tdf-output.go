@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+)
+
+// storageTransport is shared by the HTTP clients of the storage backends. It bounds how
+// long a store may take to start answering; there is no overall timeout, since bodies
+// can be large, so a stalled body is ended by the operation's context.
+var storageTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = defaultHTTPTimeout
+	return t
+}()
+
+// TDFOutputStorage describes where an encrypted TDF is written by EncryptTo.
+type TDFOutputStorage struct {
+	sink outputSink
+}
+
+// outputSink creates the destination of one encrypt.
+type outputSink interface {
+	create(ctx context.Context) (StorageWriter, error)
+	descriptor() string
+}
+
+// Creates a new file-based TDF output storage object
+func NewTDFOutputFile(filepath string) (*TDFOutputStorage, error) {
+	return &TDFOutputStorage{sink: &fileSink{path: filepath}}, nil
+}
+
+// Descriptor returns a description of the output location, e.g. "file:out.tdf".
+func (out *TDFOutputStorage) Descriptor() string {
+	return out.sink.descriptor()
+}
+
+// writeTDF runs encrypt against a writer for out, committing the output if
+// encrypt succeeds and discarding it otherwise.
+func writeTDF(ctx context.Context, out *TDFOutputStorage, encrypt func(w io.Writer) error) error {
+	w, err := out.sink.create(ctx)
+	if err != nil {
+		return err
+	}
+	if err := encrypt(w); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
+
+type fileSink struct {
+	path string
+}
+
+func (sink *fileSink) create(ctx context.Context) (StorageWriter, error) {
+	f, err := os.Create(sink.path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f}, nil
+}
+
+func (sink *fileSink) descriptor() string {
+	return "file:" + sink.path
+}
+
+type fileWriter struct {
+	*os.File
+}
+
+func (w *fileWriter) Commit() error {
+	return w.File.Close()
+}
+
+func (w *fileWriter) Abort() {
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
+
+// partUploader is the store-specific half of a chunkedWriter.
+type partUploader interface {
+	// Uploads the next full part; more will follow
+	uploadPart(part []byte) error
+	// Uploads the remaining data, which may be empty, and completes the object.
+	// parts is the number of parts already uploaded, so zero means the whole
+	// object is in last.
+	finish(last []byte, parts int) error
+	// Discards any parts uploaded so far
+	abort()
+}
+
+// chunkedWriter buffers writes into parts of partSize bytes, handing each one to
+// its uploader as soon as it fills, so an object is uploaded while it's produced.
+// A whole part is held in memory, and with growEvery set that grows with the object:
+// S3 uploads buffer 8 MiB at first, 16 MiB after 1,000 parts (8 GiB), and so on.
+type chunkedWriter struct {
+	uploader partUploader
+	partSize int
+	// If non-zero, partSize doubles after every growEvery parts, for stores that
+	// limit how many parts an object can have
+	growEvery int
+	buf       bytes.Buffer
+	parts     int
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := w.partSize - w.buf.Len()
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf.Write(p[:n])
+		p = p[n:]
+		written += n
+		if w.buf.Len() == w.partSize {
+			if err := w.uploader.uploadPart(w.buf.Bytes()); err != nil {
+				return written, err
+			}
+			w.parts++
+			w.buf.Reset()
+			if w.growEvery > 0 && w.parts%w.growEvery == 0 {
+				w.partSize *= 2
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *chunkedWriter) Commit() error {
+	if err := w.uploader.finish(w.buf.Bytes(), w.parts); err != nil {
+		w.Abort()
+		return err
+	}
+	w.buf.Reset()
+	return nil
+}
+
+func (w *chunkedWriter) Abort() {
+	w.buf.Reset()
+	w.uploader.abort()
+}
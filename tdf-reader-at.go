@@ -0,0 +1,157 @@
+package client
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+// Number of decrypted segments a TDFReaderAt keeps when the caller doesn't say
+const defaultReaderAtCacheSegments = 8
+
+// TDFReaderAt gives io.ReaderAt access to the plaintext of a TDF, for code such as
+// zip or parquet readers that expects random access. Each read decrypts only the
+// segments it touches, and recently used segments are cached. ReadAt may be called
+// concurrently. Wrap it in io.NewSectionReader(r, 0, r.Size()) where an
+// io.ReadSeeker is needed, e.g. for http.ServeContent.
+type TDFReaderAt struct {
+	segments []tdf3.SegmentInfo
+	size     int64
+	decrypt  func(seg tdf3.SegmentInfo) ([]byte, error)
+	release  func() error
+
+	mu       sync.Mutex
+	closed   bool
+	capacity int
+	// Most recently used at the front; elements hold *cachedSegment
+	lru   *list.List
+	byIdx map[int]*list.Element
+}
+
+type cachedSegment struct {
+	index     int
+	plaintext []byte
+}
+
+func newTDFReaderAt(segments []tdf3.SegmentInfo, cacheSegments int, decrypt func(seg tdf3.SegmentInfo) ([]byte, error), release func() error) *TDFReaderAt {
+	if cacheSegments <= 0 {
+		cacheSegments = defaultReaderAtCacheSegments
+	}
+	var size int64
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		size = last.PlaintextOffset + last.PlaintextSize
+	}
+	return &TDFReaderAt{
+		segments: segments,
+		size:     size,
+		decrypt:  decrypt,
+		release:  release,
+		capacity: cacheSegments,
+		lru:      list.New(),
+		byIdx:    make(map[int]*list.Element),
+	}
+}
+
+// Size returns the size of the plaintext.
+func (r *TDFReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt over the plaintext.
+func (r *TDFReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &TDFError{Kind: ErrInvalidParams, Op: "ReadAt", Err: errors.New("negative offset")}
+	}
+	if off >= r.size {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+
+	n := 0
+	i := sort.Search(len(r.segments), func(i int) bool {
+		return r.segments[i].PlaintextOffset+r.segments[i].PlaintextSize > off
+	})
+	for ; n < len(p) && i < len(r.segments); i++ {
+		copied, err := r.copySegment(p[n:], i, off+int64(n)-r.segments[i].PlaintextOffset)
+		n += copied
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// copySegment copies plaintext of segment i, from segOff on, into p.
+// Cached plaintext is only read or wiped with mu held.
+func (r *TDFReaderAt) copySegment(p []byte, i int, segOff int64) (int, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, &TDFError{Kind: ErrInvalidParams, Op: "ReadAt", Err: errors.New("reader is closed")}
+	}
+	if elem, ok := r.byIdx[i]; ok {
+		r.lru.MoveToFront(elem)
+		n := copy(p, elem.Value.(*cachedSegment).plaintext[segOff:])
+		r.mu.Unlock()
+		return n, nil
+	}
+	r.mu.Unlock()
+
+	//Decrypt without the lock, so concurrent reads of other segments aren't held up
+	plaintext, err := r.decrypt(r.segments[i])
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(plaintext)) != r.segments[i].PlaintextSize {
+		wipeBytes(plaintext)
+		return 0, &TDFError{Kind: ErrIntegrity, Op: "ReadAt", Err: errors.New("segment has unexpected size")}
+	}
+	n := copy(p, plaintext[segOff:])
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byIdx[i]; ok || r.closed {
+		//Another reader cached it first, or we're closed
+		wipeBytes(plaintext)
+		return n, nil
+	}
+	r.byIdx[i] = r.lru.PushFront(&cachedSegment{index: i, plaintext: plaintext})
+	for r.lru.Len() > r.capacity {
+		oldest := r.lru.Remove(r.lru.Back()).(*cachedSegment)
+		delete(r.byIdx, oldest.index)
+		wipeBytes(oldest.plaintext)
+	}
+	return n, nil
+}
+
+// Close wipes the cached plaintext and releases the TDF. The TDFStorage the reader
+// was created from must stay open until then.
+func (r *TDFReaderAt) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	for elem := r.lru.Front(); elem != nil; elem = elem.Next() {
+		wipeBytes(elem.Value.(*cachedSegment).plaintext)
+	}
+	r.lru.Init()
+	r.byIdx = nil
+	r.mu.Unlock()
+
+	if r.release != nil {
+		return r.release()
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+// testSegmentSize is small, so reads cross segments at offsets that are easy to pick.
+const testSegmentSize = 10
+
+// testSegmentedPlaintext is a fake TDF of plaintext split into testSegmentSize
+// segments, which hands out a new copy of a segment on each decrypt and keeps them all.
+type testSegmentedPlaintext struct {
+	plaintext []byte
+	segments  []tdf3.SegmentInfo
+
+	mu        sync.Mutex
+	decrypted map[int][][]byte
+}
+
+func newTestSegmentedPlaintext(size int) *testSegmentedPlaintext {
+	tdf := &testSegmentedPlaintext{plaintext: make([]byte, size), decrypted: make(map[int][][]byte)}
+	for i := range tdf.plaintext {
+		tdf.plaintext[i] = byte('a' + i%26)
+	}
+	for off := 0; off < size; off += testSegmentSize {
+		segSize := testSegmentSize
+		if size-off < segSize {
+			segSize = size - off
+		}
+		tdf.segments = append(tdf.segments, tdf3.SegmentInfo{Index: len(tdf.segments), PlaintextOffset: int64(off), PlaintextSize: int64(segSize)})
+	}
+	return tdf
+}
+
+func (tdf *testSegmentedPlaintext) decrypt(seg tdf3.SegmentInfo) ([]byte, error) {
+	plaintext := append([]byte(nil), tdf.plaintext[seg.PlaintextOffset:seg.PlaintextOffset+seg.PlaintextSize]...)
+	tdf.mu.Lock()
+	defer tdf.mu.Unlock()
+	tdf.decrypted[seg.Index] = append(tdf.decrypted[seg.Index], plaintext)
+	return plaintext, nil
+}
+
+// wiped reports whether every copy of segment i handed out has been wiped.
+func (tdf *testSegmentedPlaintext) wiped(i int) bool {
+	tdf.mu.Lock()
+	defer tdf.mu.Unlock()
+	for _, plaintext := range tdf.decrypted[i] {
+		if !bytes.Equal(plaintext, make([]byte, len(plaintext))) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTDFReaderAtReads(t *testing.T) {
+	tdf := newTestSegmentedPlaintext(35)
+	reader := newTDFReaderAt(tdf.segments, 2, tdf.decrypt, nil)
+	defer reader.Close()
+
+	tests := []struct {
+		name    string
+		off     int64
+		size    int
+		wantN   int
+		wantErr error
+	}{
+		{"within a segment", 2, 5, 5, nil},
+		{"across segments", 8, 15, 15, nil},
+		{"across every segment", 0, 35, 35, nil},
+		{"to the end", 30, 5, 5, nil},
+		{"short at the end", 30, 10, 5, io.EOF},
+		{"past the end", 35, 1, 0, io.EOF},
+		{"empty past the end", 40, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.size)
+			n, err := reader.ReadAt(p, tt.off)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Fatalf("ReadAt(%d bytes, %d) = %d, %v, want %d, %v", tt.size, tt.off, n, err, tt.wantN, tt.wantErr)
+			}
+			if n == 0 {
+				return
+			}
+			if want := tdf.plaintext[tt.off : tt.off+int64(n)]; !bytes.Equal(p[:n], want) {
+				t.Errorf("ReadAt() read %q, want %q", p[:n], want)
+			}
+		})
+	}
+}
+
+func TestTDFReaderAtEvictionWipes(t *testing.T) {
+	tdf := newTestSegmentedPlaintext(40)
+	reader := newTDFReaderAt(tdf.segments, 2, tdf.decrypt, nil)
+	defer reader.Close()
+
+	p := make([]byte, 1)
+	for _, off := range []int64{0, 10, 20} {
+		if _, err := reader.ReadAt(p, off); err != nil {
+			t.Fatalf("ReadAt(%d) error = %v", off, err)
+		}
+	}
+	if !tdf.wiped(0) {
+		t.Error("evicted segment 0 was not wiped")
+	}
+	if tdf.wiped(1) || tdf.wiped(2) {
+		t.Error("cached segments were wiped")
+	}
+
+	// Segment 1 is cached, so this doesn't decrypt it again
+	if _, err := reader.ReadAt(p, 10); err != nil {
+		t.Fatalf("ReadAt(10) error = %v", err)
+	}
+	tdf.mu.Lock()
+	decrypts := len(tdf.decrypted[1])
+	tdf.mu.Unlock()
+	if decrypts != 1 {
+		t.Errorf("segment 1 decrypted %d times, want once", decrypts)
+	}
+}
+
+// Run with -race.
+func TestTDFReaderAtConcurrentReads(t *testing.T) {
+	tdf := newTestSegmentedPlaintext(200)
+	reader := newTDFReaderAt(tdf.segments, 3, tdf.decrypt, nil)
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				off := int64((i*37 + j*13) % 180)
+				p := make([]byte, 20)
+				if _, err := reader.ReadAt(p, off); err != nil {
+					errs <- fmt.Errorf("worker %d: ReadAt(%d) error = %v", i, off, err)
+					return
+				}
+				if !bytes.Equal(p, tdf.plaintext[off:off+20]) {
+					errs <- fmt.Errorf("worker %d: ReadAt(%d) read %q, want %q", i, off, p, tdf.plaintext[off:off+20])
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for i := range tdf.segments {
+		if !tdf.wiped(i) {
+			t.Errorf("segment %d was not wiped on Close", i)
+		}
+	}
+}
+
+func TestTDFReaderAtClosed(t *testing.T) {
+	tdf := newTestSegmentedPlaintext(20)
+	released := 0
+	reader := newTDFReaderAt(tdf.segments, 2, tdf.decrypt, func() error {
+		released++
+		return nil
+	})
+	if _, err := reader.ReadAt(make([]byte, 5), 0); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := reader.Close(); err != nil || released != 1 {
+		t.Errorf("second Close() = %v after %d releases, want nil after 1", err, released)
+	}
+	if _, err := reader.ReadAt(make([]byte, 5), 0); !errors.Is(err, ErrInvalidParams) {
+		t.Errorf("ReadAt() after Close error = %v, want ErrInvalidParams", err)
+	}
+}
+
+func TestNativeReaderAt(t *testing.T) {
+	idp, kas := newTestIdP(t), newTestKAS(t)
+	tdfClient := newTestNativeClient(t, idp, kas)
+	ctx := context.Background()
+
+	plaintext := bytes.Repeat([]byte("0123456789"), 250<<10)
+	storage, err := NewTDFStorageBytes(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	tdf, err := tdfClient.EncryptWithOptions(ctx, storage, EncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdfStorage, err := NewTDFStorageBytes(tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdfStorage.Close()
+	reader, err := tdfClient.NewReaderAt(ctx, tdfStorage, 1)
+	if err != nil {
+		t.Fatalf("NewReaderAt() error = %v", err)
+	}
+	defer reader.Close()
+	if len(reader.segments) < 2 {
+		t.Fatalf("TDF has %d segments, want several", len(reader.segments))
+	}
+
+	// Across the first segment boundary, then short at the end
+	boundary := reader.segments[1].PlaintextOffset
+	p := make([]byte, 100)
+	if n, err := reader.ReadAt(p, boundary-50); n != 100 || err != nil || !bytes.Equal(p, plaintext[boundary-50:boundary+50]) {
+		t.Errorf("ReadAt() across segments = %d, %v, or read the wrong plaintext", n, err)
+	}
+	size := int64(len(plaintext))
+	if n, err := reader.ReadAt(p, size-40); n != 40 || err != io.EOF || !bytes.Equal(p[:n], plaintext[size-40:]) {
+		t.Errorf("ReadAt() at the end = %d, %v, want 40, io.EOF", n, err)
+	}
+}
@@ -0,0 +1,317 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+// TDFStorage describes where TDF input data lives. It carries both the
+// client-cpp storage object (when built with cgo) and a Go-side view of the
+// same data, used by the native backend.
+// TDFStorage is for input data only; see TDFOutputStorage for where EncryptTo writes.
+type TDFStorage struct {
+	cStorage
+	source storageSource
+
+	closeOnce    sync.Once
+	hasFinalizer bool
+}
+
+// storageSource gives the native backend access to the bytes behind a TDFStorage.
+type storageSource interface {
+	// readerAt returns random access to the stored data, and its size in bytes.
+	// Sources that read over the network do so with ctx.
+	readerAt(ctx context.Context) (io.ReaderAt, int64, error)
+	descriptor() string
+	close() error
+}
+
+// Creates a new S3-based TDF storage object
+func NewTDFStorageS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion string) (*TDFStorage, error) {
+	storage := TDFStorage{source: &s3Source{url: s3url}}
+	if err := storage.initS3(s3url, awsAccessKeyID, awsSecretKey, awsRegion); err != nil {
+		return nil, err
+	}
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new file-based TDF storage object
+func NewTDFStorageFile(filepath string) (*TDFStorage, error) {
+	storage := TDFStorage{source: &fileSource{path: filepath}}
+	if err := storage.initFile(filepath); err != nil {
+		return nil, err
+	}
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new string-based TDF storage object
+func NewTDFStorageString(data string) (*TDFStorage, error) {
+	inData := []byte(data)
+	storage := TDFStorage{source: &bytesSource{data: inData}}
+	if err := storage.initBytes(inData); err != nil {
+		return nil, err
+	}
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new TDF storage object over binary data, without copying it.
+// data must not be modified until the storage object is closed.
+func NewTDFStorageBytes(data []byte) (*TDFStorage, error) {
+	storage := TDFStorage{source: &bytesSource{data: data}}
+	if err := storage.initBytes(data); err != nil {
+		return nil, err
+	}
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new TDF storage object that reads from r, which is only ever read once.
+// Encrypting with the native client streams r without buffering it. Anything needing
+// random access - decrypting, or any use with the client-cpp backend - first reads
+// all of r into memory, so prefer NewTDFStorageReaderAt where the data allows it.
+func NewTDFStorageReader(r io.Reader) (*TDFStorage, error) {
+	source := &readerSource{r: r}
+	storage := TDFStorage{source: source}
+	storage.initLazy(source.readAll)
+	return storage.withFinalizer(), nil
+}
+
+// Creates a new TDF storage object over size bytes of r. The native client reads
+// only the parts it needs; the client-cpp backend reads all of it into memory first.
+func NewTDFStorageReaderAt(r io.ReaderAt, size int64) (*TDFStorage, error) {
+	if size < 0 {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "NewTDFStorageReaderAt", Err: errors.New("negative size")}
+	}
+	source := &readerAtSource{r: r, size: size}
+	storage := TDFStorage{source: source}
+	storage.initLazy(source.readAll)
+	return storage.withFinalizer(), nil
+}
+
+// Should be invoked by caller when it's done with the storage location.
+// Note that callers MUST invoke Close() on the TDFStorage object
+// when they're done with it, ideally via a `defer storage.Close()`
+// If this does not happen, memory manually allocated in the C memspace is only freed
+// if and when the storage object is garbage collected. Calling Close more than once is safe.
+func (storage *TDFStorage) Close() {
+	storage.closeOnce.Do(func() {
+		if storage.hasFinalizer {
+			runtime.SetFinalizer(storage, nil)
+		}
+		storage.cStorage.close()
+		if storage.source != nil {
+			//nolint:errcheck
+			storage.source.close()
+		}
+	})
+}
+
+// withFinalizer frees the C memory behind storage if it is garbage collected
+// without being closed. It's a safety net only: finalizers may run late or not at all.
+func (storage *TDFStorage) withFinalizer() *TDFStorage {
+	storage.hasFinalizer = true
+	runtime.SetFinalizer(storage, func(storage *TDFStorage) {
+		storage.cStorage.finalize()
+	})
+	return storage
+}
+
+// streamSource is implemented by sources that can only be read once, front to back.
+type streamSource interface {
+	stream(ctx context.Context) (io.Reader, error)
+}
+
+// plaintextStream returns the stored data as a sequential reader.
+func (storage *TDFStorage) plaintextStream(ctx context.Context) (io.Reader, error) {
+	if src, ok := storage.source.(streamSource); ok {
+		return src.stream(ctx)
+	}
+	in, size, err := storage.source.readerAt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(in, 0, size), nil
+}
+
+// openTDF reads the TDF3 container held by data on the Go side. The returned
+// reader keeps reading the payload with ctx.
+func openTDF(ctx context.Context, data *TDFStorage) (*tdf3.Reader, error) {
+	in, size, err := data.source.readerAt(ctx)
+	if err != nil {
+		return nil, &TDFError{Kind: ErrInvalidParams, Op: "open TDF storage", Err: err}
+	}
+	reader, err := tdf3.NewReader(in, size)
+	if err != nil {
+		return nil, translateTDF3Error("read TDF", err)
+	}
+	return reader, nil
+}
+
+type fileSource struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (src *fileSource) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.file == nil {
+		f, err := os.Open(src.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		src.file, src.size = f, info.Size()
+	}
+	return src.file, src.size, nil
+}
+
+func (src *fileSource) descriptor() string {
+	return "file:" + src.path
+}
+
+func (src *fileSource) close() error {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.file == nil {
+		return nil
+	}
+	err := src.file.Close()
+	src.file = nil
+	return err
+}
+
+type bytesSource struct {
+	data []byte
+}
+
+func (src *bytesSource) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	return bytes.NewReader(src.data), int64(len(src.data)), nil
+}
+
+func (src *bytesSource) descriptor() string {
+	return "string"
+}
+
+func (src *bytesSource) close() error {
+	return nil
+}
+
+type s3Source struct {
+	url string
+}
+
+func (src *s3Source) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	return nil, 0, errors.New("S3 storage is not supported by the native TDF client")
+}
+
+func (src *s3Source) descriptor() string {
+	return "s3:" + src.url
+}
+
+func (src *s3Source) close() error {
+	return nil
+}
+
+type readerSource struct {
+	mu       sync.Mutex
+	r        io.Reader
+	consumed bool
+	// Set once r has been read into memory for random access
+	data     []byte
+	buffered bool
+}
+
+func (src *readerSource) stream(ctx context.Context) (io.Reader, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if src.buffered {
+		return bytes.NewReader(src.data), nil
+	}
+	if src.consumed {
+		return nil, errReaderConsumed
+	}
+	src.consumed = true
+	return src.r, nil
+}
+
+func (src *readerSource) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	data, err := src.readAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+func (src *readerSource) readAll() ([]byte, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if !src.buffered {
+		if src.consumed {
+			return nil, errReaderConsumed
+		}
+		src.consumed = true
+		data, err := io.ReadAll(src.r)
+		if err != nil {
+			return nil, err
+		}
+		src.data, src.buffered = data, true
+	}
+	return src.data, nil
+}
+
+func (src *readerSource) descriptor() string {
+	return "reader"
+}
+
+func (src *readerSource) close() error {
+	return nil
+}
+
+var errReaderConsumed = errors.New("TDF storage reader has already been read")
+
+type readerAtSource struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func (src *readerAtSource) readerAt(ctx context.Context) (io.ReaderAt, int64, error) {
+	return src.r, src.size, nil
+}
+
+func (src *readerAtSource) readAll() ([]byte, error) {
+	data := make([]byte, src.size)
+	//ReadAt may return io.EOF alongside a full read of the final bytes
+	if n, err := src.r.ReadAt(data, 0); n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (src *readerAtSource) descriptor() string {
+	return "readerat"
+}
+
+func (src *readerAtSource) close() error {
+	return nil
+}
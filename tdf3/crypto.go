@@ -0,0 +1,218 @@
+package tdf3
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// KeySize is the size in bytes of the AES-256 payload key.
+	KeySize = 32
+	// Algorithm is the payload encryption method written to the manifest.
+	Algorithm = "AES-256-GCM"
+
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+	// Each encrypted segment is nonce || ciphertext || tag
+	segmentOverhead = gcmNonceSize + gcmTagSize
+
+	hashAlgGMAC  = "GMAC"
+	hashAlgHS256 = "HS256"
+)
+
+// ErrIntegrity is returned when a segment hash, the root signature or a
+// policy binding does not match.
+var ErrIntegrity = errors.New("tdf3: integrity check failed")
+
+// GenerateKey returns a new random payload key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a payload key with a KAS public key (RSA-OAEP, SHA-1), as
+// stored in KeyAccess.WrappedKey.
+func WrapKey(pub *rsa.PublicKey, key []byte) (string, error) {
+	wrapped, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapKey is the inverse of WrapKey.
+func UnwrapKey(priv *rsa.PrivateKey, wrappedKey string) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("tdf3: wrapped key is not valid base64: %w", err)
+	}
+	return rsa.DecryptOAEP(sha1.New(), rand.Reader, priv, wrapped, nil)
+}
+
+// PolicyBinding computes the binding between a payload key and the base64
+// encoded policy, as stored in KeyAccess.PolicyBinding. Like the signatures,
+// it is the base64 of the hex HMAC digest, which is what KAS checks on rewrap.
+func PolicyBinding(key []byte, base64Policy string) string {
+	return encodeSignature(hexBytes(hmacSHA256(key, []byte(base64Policy))))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("tdf3: payload key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSegment encrypts one plaintext segment, returning nonce || ciphertext || tag.
+func sealSegment(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	out := make([]byte, gcmNonceSize, gcmNonceSize+len(plaintext)+gcmTagSize)
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[:gcmNonceSize], plaintext, nil), nil
+}
+
+// openSegment decrypts one encrypted segment produced by sealSegment, appending
+// the plaintext to dst.
+func openSegment(aead cipher.AEAD, dst, segment []byte) ([]byte, error) {
+	if len(segment) < segmentOverhead {
+		return nil, fmt.Errorf("%w: segment too short", ErrIntegrity)
+	}
+	out, err := aead.Open(dst, segment[:gcmNonceSize], segment[gcmNonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIntegrity, err)
+	}
+	return out, nil
+}
+
+// segmentSignature returns the hash of an encrypted segment for the given
+// algorithm, in the (hex) form that is base64 encoded into the manifest.
+func segmentSignature(alg string, key, segment []byte) ([]byte, error) {
+	switch alg {
+	case hashAlgGMAC:
+		if len(segment) < gcmTagSize {
+			return nil, fmt.Errorf("%w: segment too short", ErrIntegrity)
+		}
+		return hexBytes(segment[len(segment)-gcmTagSize:]), nil
+	case hashAlgHS256:
+		return hexBytes(hmacSHA256(key, segment)), nil
+	default:
+		return nil, fmt.Errorf("tdf3: unsupported segment hash algorithm %q", alg)
+	}
+}
+
+// rootSignature signs the concatenation of all segment signatures.
+func rootSignature(alg string, key, aggregate []byte) ([]byte, error) {
+	switch alg {
+	case hashAlgHS256, hashAlgGMAC:
+		return hexBytes(hmacSHA256(key, aggregate)), nil
+	default:
+		return nil, fmt.Errorf("tdf3: unsupported root signature algorithm %q", alg)
+	}
+}
+
+// verifySignature compares a computed (hex) signature against the base64
+// value from the manifest. Older writers stored the raw digest instead of its
+// hex form, so both are accepted.
+func verifySignature(computedHex []byte, manifestValue string) bool {
+	stored, err := base64.StdEncoding.DecodeString(manifestValue)
+	if err != nil {
+		return false
+	}
+	if subtle.ConstantTimeCompare(computedHex, stored) == 1 {
+		return true
+	}
+	raw, err := hex.DecodeString(string(computedHex))
+	return err == nil && subtle.ConstantTimeCompare(raw, stored) == 1
+}
+
+func encodeSignature(hexSig []byte) string {
+	return base64.StdEncoding.EncodeToString(hexSig)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hexBytes(b []byte) []byte {
+	out := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(out, b)
+	return out
+}
+
+type encryptedMetadata struct {
+	Ciphertext string `json:"ciphertext"`
+	IV         string `json:"iv"`
+}
+
+// EncryptMetadata encrypts a metadata string with the payload key, as stored in
+// KeyAccess.EncryptedMetadata.
+func EncryptMetadata(key []byte, metadata string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealSegment(aead, []byte(metadata))
+	if err != nil {
+		return "", err
+	}
+
+	metaJSON, err := json.Marshal(encryptedMetadata{
+		Ciphertext: base64.StdEncoding.EncodeToString(sealed),
+		IV:         base64.StdEncoding.EncodeToString(sealed[:gcmNonceSize]),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(metaJSON), nil
+}
+
+// DecryptMetadata is the inverse of EncryptMetadata. An empty input yields an
+// empty result.
+func DecryptMetadata(key []byte, encrypted string) (string, error) {
+	if encrypted == "" {
+		return "", nil
+	}
+	metaJSON, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("tdf3: encrypted metadata is not valid base64: %w", err)
+	}
+	var meta encryptedMetadata
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		return "", fmt.Errorf("tdf3: encrypted metadata is not valid JSON: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(meta.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("tdf3: encrypted metadata is not valid base64: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	plain, err := openSegment(aead, nil, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,85 @@
+package tdf3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+// A key, policy and binding in the form client-cpp writes them: the base64 of the
+// hex HMAC-SHA256 digest of the base64 policy.
+var policyBindingFixture = struct {
+	key          []byte
+	base64Policy string
+	binding      string
+}{
+	key: []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
+	},
+	base64Policy: "eyJ1dWlkIjoiM2EyYzdkMmUtOGIxZi00YzYxLTlhMmQtNmYwZTViN2M0ZDIxIiwiYm9keSI6eyJkYXRhQXR0cmlidXRlcyI6W3siYXR0cmlidXRlIjoiaHR0cHM6Ly9leGFtcGxlLmNvbS9hdHRyL0NsYXNzaWZpY2F0aW9uL3ZhbHVlL1MifV0sImRpc3NlbSI6WyJ1c2VyQGV4YW1wbGUuY29tIl19fQ==",
+	binding:      "NjY3NDk4ODYzNjQ4ZGUzZDk0YzZiZWNkY2Q2NDgyNmMyNjBkNGFjMDI4YTYzNjNmYmJkNTRmM2ExZjc5ZDBlYg==",
+}
+
+func TestPolicyBindingFixture(t *testing.T) {
+	fixture := policyBindingFixture
+	if got := PolicyBinding(fixture.key, fixture.base64Policy); got != fixture.binding {
+		t.Errorf("PolicyBinding() = %s, want %s", got, fixture.binding)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	kasKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("0123456789"), 1000)
+
+	var tdf bytes.Buffer
+	_, err = Encrypt(&tdf, bytes.NewReader(plaintext), EncryptOptions{
+		KAS:         []KASInfo{{URL: "https://kas.example.com", PublicKey: &kasKey.PublicKey}},
+		Policy:      Policy{Body: PolicyBody{DataAttributes: []Attribute{{Attribute: "https://example.com/attr/A/value/1"}}}},
+		Metadata:    "some metadata",
+		SegmentSize: 4096,
+	})
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(tdf.Bytes()), int64(tdf.Len()))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	kao := r.KeyAccess()[0]
+	key, err := UnwrapKey(kasKey, kao.WrappedKey)
+	if err != nil {
+		t.Fatalf("UnwrapKey() error = %v", err)
+	}
+
+	info := r.EncryptionInformation()
+	if want := PolicyBinding(key, info.Policy); kao.PolicyBinding != want {
+		t.Errorf("policy binding = %s, want %s", kao.PolicyBinding, want)
+	}
+	// The same hex form as the segment hashes and root signature
+	if len(kao.PolicyBinding) != len(info.IntegrityInformation.RootSignature.Signature) {
+		t.Errorf("policy binding %s is not in the form of root signature %s", kao.PolicyBinding, info.IntegrityInformation.RootSignature.Signature)
+	}
+	metadata, err := DecryptMetadata(key, kao.EncryptedMetadata)
+	if err != nil || metadata != "some metadata" {
+		t.Errorf("DecryptMetadata() = %q, %v", metadata, err)
+	}
+
+	decryptor, err := r.NewDecryptor(key)
+	if err != nil {
+		t.Fatalf("NewDecryptor() error = %v", err)
+	}
+	defer decryptor.Close()
+	var out bytes.Buffer
+	if _, err := decryptor.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Error("decrypted payload does not match the plaintext")
+	}
+}
@@ -0,0 +1,277 @@
+package tdf3
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrClosed is returned when decrypting with a Decryptor that has been closed.
+var ErrClosed = errors.New("tdf3: decryptor is closed")
+
+// Decryptor decrypts the payload of a TDF3 container once the payload key has
+// been obtained (usually by a KAS rewrap of one of the key access objects).
+type Decryptor struct {
+	// Guards key and aead, which Close drops
+	mu       sync.RWMutex
+	key      []byte
+	aead     cipher.AEAD
+	hashAlg  string
+	segments []SegmentInfo
+	payload  *io.SectionReader
+}
+
+// NewDecryptor validates the payload key against the manifest root signature
+// and returns a Decryptor for the payload.
+func (r *Reader) NewDecryptor(key []byte) (*Decryptor, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := r.PayloadReaderAt()
+	if err != nil {
+		return nil, err
+	}
+	integrity := &r.manifest.EncryptionInformation.IntegrityInformation
+	segments, err := r.Segments()
+	if err != nil {
+		return nil, err
+	}
+
+	var aggregateHash []byte
+	for _, seg := range segments {
+		hash, err := base64.StdEncoding.DecodeString(seg.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("%w: segment %d hash: %s", ErrIntegrity, seg.Index, err)
+		}
+		aggregateHash = append(aggregateHash, hash...)
+	}
+	rootSig, err := rootSignature(integrity.RootSignature.Algorithm, key, aggregateHash)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(rootSig, integrity.RootSignature.Signature) {
+		return nil, fmt.Errorf("%w: root signature mismatch", ErrIntegrity)
+	}
+
+	return &Decryptor{
+		key:      append([]byte(nil), key...),
+		aead:     aead,
+		hashAlg:  integrity.SegmentHashAlg,
+		segments: segments,
+		payload:  payload,
+	}, nil
+}
+
+// Segments returns the payload segment table.
+func (d *Decryptor) Segments() []SegmentInfo {
+	return d.segments
+}
+
+// PlaintextSize returns the total size of the decrypted payload.
+func (d *Decryptor) PlaintextSize() int64 {
+	if len(d.segments) == 0 {
+		return 0
+	}
+	last := d.segments[len(d.segments)-1]
+	return last.PlaintextOffset + last.PlaintextSize
+}
+
+// DecryptSegment reads, verifies and decrypts the segment with the given index.
+func (d *Decryptor) DecryptSegment(index int) ([]byte, error) {
+	if index < 0 || index >= len(d.segments) {
+		return nil, fmt.Errorf("tdf3: segment index %d out of range", index)
+	}
+	seg := d.segments[index]
+
+	sealed := make([]byte, seg.EncryptedSize)
+	if _, err := d.payload.ReadAt(sealed, seg.EncryptedOffset); err != nil {
+		return nil, fmt.Errorf("tdf3: reading segment %d: %w", index, err)
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.aead == nil {
+		return nil, ErrClosed
+	}
+	hash, err := segmentSignature(d.hashAlg, d.key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(hash, seg.Hash) {
+		return nil, fmt.Errorf("%w: segment %d hash mismatch", ErrIntegrity, index)
+	}
+
+	plain, err := openSegment(d.aead, make([]byte, 0, seg.PlaintextSize), sealed)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(plain)) != seg.PlaintextSize {
+		return nil, fmt.Errorf("%w: segment %d has unexpected size", ErrIntegrity, index)
+	}
+	return plain, nil
+}
+
+// WriteTo decrypts the whole payload into w, one segment at a time.
+func (d *Decryptor) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for i := range d.segments {
+		plain, err := d.DecryptSegment(i)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.Write(plain)
+		wipe(plain)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// DecryptRange decrypts length bytes of plaintext starting at offset, reading
+// only the segments that cover the range. The result is shorter than length
+// if the range extends past the end of the payload.
+func (d *Decryptor) DecryptRange(offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("tdf3: invalid range offset %d length %d", offset, length)
+	}
+	end := d.PlaintextSize()
+	if length < end-offset {
+		end = offset + length
+	}
+	if offset >= end {
+		return []byte{}, nil
+	}
+
+	// Only the segments overlapping [offset, end) are read from the payload
+	out := make([]byte, 0, end-offset)
+	for i := d.segmentAt(offset); i < len(d.segments) && d.segments[i].PlaintextOffset < end; i++ {
+		seg := d.segments[i]
+		plain, err := d.DecryptSegment(seg.Index)
+		if err != nil {
+			return nil, err
+		}
+		from := max64(offset, seg.PlaintextOffset) - seg.PlaintextOffset
+		to := min64(end, seg.PlaintextOffset+seg.PlaintextSize) - seg.PlaintextOffset
+		out = append(out, plain[from:to]...)
+		wipe(plain)
+	}
+	return out, nil
+}
+
+// segmentAt returns the index of the segment holding plaintext offset off.
+func (d *Decryptor) segmentAt(off int64) int {
+	return sort.Search(len(d.segments), func(i int) bool {
+		return d.segments[i].PlaintextOffset+d.segments[i].PlaintextSize > off
+	})
+}
+
+// NewReader returns a reader over the decrypted payload. Segments are decrypted
+// and their integrity verified as they are consumed, so only one segment of
+// plaintext is held in memory at a time. Closing the reader closes the Decryptor.
+func (d *Decryptor) NewReader() *PlaintextReader {
+	return &PlaintextReader{d: d, segment: -1}
+}
+
+// PlaintextReader implements io.ReadSeekCloser and io.ReaderAt over the
+// decrypted payload. Read and Seek must not be called concurrently; ReadAt may be.
+type PlaintextReader struct {
+	d       *Decryptor
+	pos     int64
+	segment int
+	plain   []byte
+}
+
+func (pr *PlaintextReader) Read(p []byte) (int, error) {
+	if pr.pos >= pr.d.PlaintextSize() {
+		return 0, io.EOF
+	}
+	index := pr.d.segmentAt(pr.pos)
+	if index != pr.segment {
+		wipe(pr.plain)
+		pr.plain, pr.segment = nil, -1
+		plain, err := pr.d.DecryptSegment(index)
+		if err != nil {
+			return 0, err
+		}
+		pr.plain, pr.segment = plain, index
+	}
+
+	n := copy(p, pr.plain[pr.pos-pr.d.segments[index].PlaintextOffset:])
+	pr.pos += int64(n)
+	return n, nil
+}
+
+func (pr *PlaintextReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = pr.pos + offset
+	case io.SeekEnd:
+		abs = pr.d.PlaintextSize() + offset
+	default:
+		return 0, errors.New("tdf3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("tdf3: negative position")
+	}
+	pr.pos = abs
+	return abs, nil
+}
+
+func (pr *PlaintextReader) ReadAt(p []byte, off int64) (int, error) {
+	plain, err := pr.d.DecryptRange(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, plain)
+	wipe(plain)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns the total size of the decrypted payload.
+func (pr *PlaintextReader) Size() int64 {
+	return pr.d.PlaintextSize()
+}
+
+// Close wipes the buffered plaintext segment and closes the Decryptor.
+func (pr *PlaintextReader) Close() error {
+	wipe(pr.plain)
+	pr.plain, pr.segment = nil, -1
+	return pr.d.Close()
+}
+
+// Close wipes the payload key held by the Decryptor and drops the cipher built
+// from it; decrypting afterwards fails with ErrClosed.
+func (d *Decryptor) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wipe(d.key)
+	d.key, d.aead = nil, nil
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
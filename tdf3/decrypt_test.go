@@ -0,0 +1,138 @@
+package tdf3
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+// encryptForTest encrypts plaintext in segmentSize segments, returning the TDF and its payload key.
+func encryptForTest(t *testing.T, plaintext []byte, segmentSize int64) ([]byte, []byte) {
+	t.Helper()
+	kasKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tdf bytes.Buffer
+	manifest, err := Encrypt(&tdf, bytes.NewReader(plaintext), EncryptOptions{
+		KAS:         []KASInfo{{URL: "https://kas.example.com", PublicKey: &kasKey.PublicKey}},
+		SegmentSize: segmentSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := UnwrapKey(kasKey, manifest.EncryptionInformation.KeyAccess[0].WrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tdf.Bytes(), key
+}
+
+// rewriteManifest returns tdf with its manifest changed by edit, and its payload as is.
+func rewriteManifest(t *testing.T, tdf []byte, edit func(*Manifest)) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(tdf), int64(len(tdf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == ManifestFileName {
+			var manifest Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			edit(&manifest)
+			if data, err = json.Marshal(&manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestDecryptorRejectsBadSegmentSizes(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("x"), 3000)
+	tdf, key := encryptForTest(t, plaintext, 1000)
+
+	tests := []struct {
+		name string
+		edit func(*IntegrityInformation)
+	}{
+		{"encrypted size past payload", func(info *IntegrityInformation) {
+			info.Segments[1].EncryptedSegmentSize = 1 << 62
+		}},
+		{"last segment past payload", func(info *IntegrityInformation) {
+			info.Segments[2].EncryptedSegmentSize += 1
+		}},
+		{"plaintext larger than ciphertext", func(info *IntegrityInformation) {
+			info.Segments[0].SegmentSize = info.Segments[0].EncryptedSegmentSize - segmentOverhead + 1
+		}},
+		{"huge plaintext size", func(info *IntegrityInformation) {
+			info.Segments[0].SegmentSize = 1 << 62
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := rewriteManifest(t, tdf, func(manifest *Manifest) {
+				tt.edit(&manifest.EncryptionInformation.IntegrityInformation)
+			})
+			r, err := NewReader(bytes.NewReader(tampered), int64(len(tampered)))
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+			if _, err := r.Segments(); !errors.Is(err, ErrIntegrity) {
+				t.Errorf("Segments() error = %v, want ErrIntegrity", err)
+			}
+			if _, err := r.NewDecryptor(key); !errors.Is(err, ErrIntegrity) {
+				t.Errorf("NewDecryptor() error = %v, want ErrIntegrity", err)
+			}
+		})
+	}
+}
+
+func TestDecryptorCloseStopsDecryption(t *testing.T) {
+	tdf, key := encryptForTest(t, []byte("hello world"), 0)
+	r, err := NewReader(bytes.NewReader(tdf), int64(len(tdf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := r.NewDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptor.DecryptSegment(0); err != nil || string(plain) != "hello world" {
+		t.Fatalf("DecryptSegment() = %q, %v", plain, err)
+	}
+	if err := decryptor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptor.DecryptSegment(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("DecryptSegment() after Close error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package tdf3 reads OpenTDF TDF3 containers in pure Go, with no dependency on
+// the client-cpp SDK or cgo.
+//
+// A TDF3 file is a zip archive holding an encrypted payload (0.payload) and a
+// JSON manifest (0.manifest.json) describing how it was encrypted.
+// See https://github.com/opentdf/spec for the format definition.
+package tdf3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// ManifestFileName is the name of the manifest entry inside a TDF3 zip.
+	ManifestFileName = "0.manifest.json"
+	// PayloadFileName is the name of the encrypted payload entry inside a TDF3 zip.
+	PayloadFileName = "0.payload"
+)
+
+// See https://github.com/opentdf/spec/blob/master/schema/manifest.md
+type Manifest struct {
+	Payload               Payload               `json:"payload"`
+	EncryptionInformation EncryptionInformation `json:"encryptionInformation"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/PayloadReference.md
+type Payload struct {
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	Protocol    string `json:"protocol"`
+	MimeType    string `json:"mimeType,omitempty"`
+	IsEncrypted bool   `json:"isEncrypted"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/EncryptionInformation.md
+type EncryptionInformation struct {
+	Type                 string               `json:"type"`
+	KeyAccess            []KeyAccess          `json:"keyAccess"`
+	Method               Method               `json:"method"`
+	IntegrityInformation IntegrityInformation `json:"integrityInformation"`
+	// Base64 encoded JSON policy object, see Policy
+	Policy string `json:"policy"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/KeyAccessObject.md
+type KeyAccess struct {
+	Type              string `json:"type"`
+	URL               string `json:"url"`
+	Protocol          string `json:"protocol"`
+	WrappedKey        string `json:"wrappedKey"`
+	PolicyBinding     string `json:"policyBinding"`
+	EncryptedMetadata string `json:"encryptedMetadata,omitempty"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/EncryptionInformation.md#method
+type Method struct {
+	Algorithm    string `json:"algorithm"`
+	IsStreamable bool   `json:"isStreamable"`
+	IV           string `json:"iv"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/EncryptionInformation.md#integrityinformation
+type IntegrityInformation struct {
+	RootSignature               RootSignature `json:"rootSignature"`
+	SegmentSizeDefault          int64         `json:"segmentSizeDefault"`
+	SegmentHashAlg              string        `json:"segmentHashAlg"`
+	Segments                    []Segment     `json:"segments"`
+	EncryptedSegmentSizeDefault int64         `json:"encryptedSegmentSizeDefault"`
+}
+
+type RootSignature struct {
+	Algorithm string `json:"alg"`
+	Signature string `json:"sig"`
+}
+
+// Segment sizes may be omitted from the manifest, in which case the
+// IntegrityInformation defaults apply.
+type Segment struct {
+	Hash                 string `json:"hash"`
+	SegmentSize          int64  `json:"segmentSize,omitempty"`
+	EncryptedSegmentSize int64  `json:"encryptedSegmentSize,omitempty"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/AttributeObject.md
+type Attribute struct {
+	Attribute string `json:"attribute"`
+}
+
+// See https://github.com/opentdf/spec/blob/master/schema/PolicyObject.md
+type Policy struct {
+	UUID        string     `json:"uuid"`
+	Body        PolicyBody `json:"body"`
+	SpecVersion string     `json:"tdf_spec_version,omitempty"`
+}
+
+type PolicyBody struct {
+	DataAttributes    []Attribute `json:"dataAttributes"`
+	DisseminationList []string    `json:"dissem"`
+}
+
+// DecodePolicy base64 decodes and parses the policy object embedded in the manifest.
+func (info *EncryptionInformation) DecodePolicy() (*Policy, error) {
+	policyJSON, err := base64.StdEncoding.DecodeString(info.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("tdf3: policy is not valid base64: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(policyJSON, &policy); err != nil {
+		return nil, fmt.Errorf("tdf3: policy is not valid JSON: %w", err)
+	}
+	return &policy, nil
+}
+
+// SegmentInfo is a manifest segment with its defaults resolved and its
+// position within the plaintext and the encrypted payload computed.
+type SegmentInfo struct {
+	Index           int
+	Hash            string
+	PlaintextOffset int64
+	PlaintextSize   int64
+	EncryptedOffset int64
+	EncryptedSize   int64
+}
+
+// SegmentTable resolves the segment list from the manifest into absolute
+// plaintext and payload offsets. The sizes aren't covered by the root signature,
+// so a segment whose plaintext can't fit in its encrypted size is rejected with
+// ErrIntegrity. Use Reader.Segments to also check them against the payload.
+func (info *IntegrityInformation) SegmentTable() ([]SegmentInfo, error) {
+	table := make([]SegmentInfo, 0, len(info.Segments))
+	var plainOffset, encOffset int64
+	for i, seg := range info.Segments {
+		plainSize := seg.SegmentSize
+		if plainSize == 0 {
+			plainSize = info.SegmentSizeDefault
+		}
+		encSize := seg.EncryptedSegmentSize
+		if encSize == 0 {
+			encSize = info.EncryptedSegmentSizeDefault
+		}
+		if plainSize < 0 || encSize <= 0 {
+			return nil, fmt.Errorf("tdf3: segment %d has invalid size", i)
+		}
+		if plainSize > encSize-segmentOverhead {
+			return nil, fmt.Errorf("%w: segment %d plaintext size %d does not fit in encrypted size %d", ErrIntegrity, i, plainSize, encSize)
+		}
+
+		table = append(table, SegmentInfo{
+			Index:           i,
+			Hash:            seg.Hash,
+			PlaintextOffset: plainOffset,
+			PlaintextSize:   plainSize,
+			EncryptedOffset: encOffset,
+			EncryptedSize:   encSize,
+		})
+		plainOffset += plainSize
+		encOffset += encSize
+	}
+	return table, nil
+}
+
+// checkPayloadBounds returns ErrIntegrity if a segment of table extends past the end
+// of a payload of payloadSize bytes.
+func checkPayloadBounds(table []SegmentInfo, payloadSize int64) error {
+	for _, seg := range table {
+		if seg.EncryptedSize > payloadSize-seg.EncryptedOffset {
+			return fmt.Errorf("%w: segment %d extends past the end of the %d byte payload", ErrIntegrity, seg.Index, payloadSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package tdf3
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotTDF is returned when the input is not a well-formed TDF3 zip container.
+var ErrNotTDF = errors.New("tdf3: not a TDF3 container")
+
+// Reader gives access to the manifest and encrypted payload of a TDF3 container.
+// It only parses the container - decrypting the payload requires the payload
+// key, which must be obtained from a KAS.
+type Reader struct {
+	manifest Manifest
+	payload  *zip.File
+	src      io.ReaderAt
+	size     int64
+	closer   io.Closer
+}
+
+// Open opens the named TDF3 file. Callers must Close the returned Reader.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r, err := NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewReader parses the TDF3 container held in r, which has the given size in bytes.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotTDF, err)
+	}
+
+	var manifestFile *zip.File
+	for _, f := range zr.File {
+		if f.Name == ManifestFileName {
+			manifestFile = f
+			break
+		}
+	}
+	if manifestFile == nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrNotTDF, ManifestFileName)
+	}
+
+	tdf := Reader{src: r, size: size}
+	if err := readManifest(manifestFile, &tdf.manifest); err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if f.Name == tdf.manifest.Payload.URL {
+			tdf.payload = f
+			break
+		}
+	}
+	if tdf.payload == nil {
+		return nil, fmt.Errorf("%w: missing payload %q", ErrNotTDF, tdf.manifest.Payload.URL)
+	}
+
+	return &tdf, nil
+}
+
+func readManifest(f *zip.File, manifest *Manifest) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotTDF, err)
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(manifest); err != nil {
+		return fmt.Errorf("%w: bad manifest: %s", ErrNotTDF, err)
+	}
+	return nil
+}
+
+// Close releases the underlying file, if the Reader was created with Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Manifest returns the parsed manifest. The returned value must not be modified.
+func (r *Reader) Manifest() *Manifest {
+	return &r.manifest
+}
+
+// EncryptionInformation returns the manifest encryption information.
+func (r *Reader) EncryptionInformation() *EncryptionInformation {
+	return &r.manifest.EncryptionInformation
+}
+
+// KeyAccess returns the key access objects that can be used to unwrap the payload key.
+func (r *Reader) KeyAccess() []KeyAccess {
+	return r.manifest.EncryptionInformation.KeyAccess
+}
+
+// Policy decodes the policy embedded in the manifest.
+func (r *Reader) Policy() (*Policy, error) {
+	return r.manifest.EncryptionInformation.DecodePolicy()
+}
+
+// Segments returns the payload segment table with absolute offsets, checked
+// against the payload size.
+func (r *Reader) Segments() ([]SegmentInfo, error) {
+	table, err := r.manifest.EncryptionInformation.IntegrityInformation.SegmentTable()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPayloadBounds(table, r.PayloadSize()); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// PayloadSize returns the size of the encrypted payload in bytes.
+func (r *Reader) PayloadSize() int64 {
+	return int64(r.payload.UncompressedSize64)
+}
+
+// OpenPayload returns a sequential reader over the encrypted payload.
+func (r *Reader) OpenPayload() (io.ReadCloser, error) {
+	return r.payload.Open()
+}
+
+// PayloadReaderAt returns random access to the encrypted payload. This is only
+// possible when the payload is stored uncompressed, which is always the case
+// for TDFs produced by the OpenTDF SDKs.
+func (r *Reader) PayloadReaderAt() (*io.SectionReader, error) {
+	if r.payload.Method != zip.Store {
+		return nil, fmt.Errorf("tdf3: payload is compressed with method %d, random access unavailable", r.payload.Method)
+	}
+	offset, err := r.payload.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	if size := r.PayloadSize(); size < 0 || size > r.size-offset {
+		return nil, fmt.Errorf("%w: payload extends past the end of the container", ErrNotTDF)
+	}
+	return io.NewSectionReader(r.src, offset, r.PayloadSize()), nil
+}
@@ -0,0 +1,237 @@
+package tdf3
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// DefaultSegmentSize is the plaintext segment size used when none is given,
+	// matching the client-cpp default.
+	DefaultSegmentSize = 1024 * 1024
+	// DefaultMimeType is written to the payload reference when none is given.
+	DefaultMimeType = "application/octet-stream"
+)
+
+// KASInfo identifies a KAS the payload key is wrapped for.
+type KASInfo struct {
+	URL       string
+	PublicKey *rsa.PublicKey
+}
+
+// EncryptOptions controls how Encrypt builds a TDF3 container.
+type EncryptOptions struct {
+	// KAS instances that will be able to rewrap the payload key. At least one is required.
+	KAS []KASInfo
+	// Policy to bind to the payload key. A UUID is generated if the policy does not have one.
+	Policy Policy
+	// Metadata is optional, and is encrypted into every key access object.
+	Metadata string
+	// SegmentSize is the plaintext size of each payload segment, DefaultSegmentSize if zero.
+	SegmentSize int64
+	// MimeType of the plaintext, DefaultMimeType if empty.
+	MimeType string
+}
+
+// Encrypt reads plaintext from r until EOF and writes a TDF3 container to w.
+// The plaintext is encrypted one segment at a time, so memory use is bounded
+// by the segment size regardless of the input size.
+func Encrypt(w io.Writer, r io.Reader, opts EncryptOptions) (*Manifest, error) {
+	if len(opts.KAS) == 0 {
+		return nil, errors.New("tdf3: at least one KAS is required to encrypt")
+	}
+	segmentSize := opts.SegmentSize
+	if segmentSize == 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if segmentSize < 0 {
+		return nil, fmt.Errorf("tdf3: invalid segment size %d", segmentSize)
+	}
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = DefaultMimeType
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := opts.Policy
+	if policy.UUID == "" {
+		if policy.UUID, err = newUUID(); err != nil {
+			return nil, err
+		}
+	}
+	if policy.Body.DataAttributes == nil {
+		policy.Body.DataAttributes = []Attribute{}
+	}
+	if policy.Body.DisseminationList == nil {
+		policy.Body.DisseminationList = []string{}
+	}
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	base64Policy := base64.StdEncoding.EncodeToString(policyJSON)
+
+	var keyAccess []KeyAccess
+	for _, kas := range opts.KAS {
+		kao, err := newKeyAccess(kas, key, base64Policy, opts.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		keyAccess = append(keyAccess, kao)
+	}
+
+	zw := zip.NewWriter(w)
+	payloadWriter, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     PayloadFileName,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	var aggregateHash []byte
+	var firstIV string
+	buf := make([]byte, segmentSize)
+	defer wipe(buf)
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			sealed, err := sealSegment(aead, buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			if firstIV == "" {
+				firstIV = base64.StdEncoding.EncodeToString(sealed[:gcmNonceSize])
+			}
+			if _, err := payloadWriter.Write(sealed); err != nil {
+				return nil, err
+			}
+			hash, err := segmentSignature(hashAlgGMAC, key, sealed)
+			if err != nil {
+				return nil, err
+			}
+			aggregateHash = append(aggregateHash, hash...)
+			segments = append(segments, Segment{
+				Hash:                 encodeSignature(hash),
+				SegmentSize:          int64(n),
+				EncryptedSegmentSize: int64(len(sealed)),
+			})
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	rootSig, err := rootSignature(hashAlgHS256, key, aggregateHash)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{
+		Payload: Payload{
+			Type:        "reference",
+			URL:         PayloadFileName,
+			Protocol:    "zip",
+			MimeType:    mimeType,
+			IsEncrypted: true,
+		},
+		EncryptionInformation: EncryptionInformation{
+			Type:      "split",
+			KeyAccess: keyAccess,
+			Method: Method{
+				Algorithm:    Algorithm,
+				IsStreamable: true,
+				IV:           firstIV,
+			},
+			IntegrityInformation: IntegrityInformation{
+				RootSignature: RootSignature{
+					Algorithm: hashAlgHS256,
+					Signature: encodeSignature(rootSig),
+				},
+				SegmentSizeDefault:          segmentSize,
+				SegmentHashAlg:              hashAlgGMAC,
+				Segments:                    segments,
+				EncryptedSegmentSizeDefault: segmentSize + segmentOverhead,
+			},
+			Policy: base64Policy,
+		},
+	}
+
+	manifestWriter, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     ManifestFileName,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(manifestWriter).Encode(&manifest); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func newKeyAccess(kas KASInfo, key []byte, base64Policy, metadata string) (KeyAccess, error) {
+	if kas.PublicKey == nil {
+		return KeyAccess{}, fmt.Errorf("tdf3: no public key for KAS %s", kas.URL)
+	}
+	wrappedKey, err := WrapKey(kas.PublicKey, key)
+	if err != nil {
+		return KeyAccess{}, err
+	}
+
+	kao := KeyAccess{
+		Type:          "wrapped",
+		URL:           kas.URL,
+		Protocol:      "kas",
+		WrappedKey:    wrappedKey,
+		PolicyBinding: PolicyBinding(key, base64Policy),
+	}
+	if metadata != "" {
+		if kao.EncryptedMetadata, err = EncryptMetadata(key, metadata); err != nil {
+			return KeyAccess{}, err
+		}
+	}
+	return kao, nil
+}
+
+// newUUID returns a random (version 4) UUID string.
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opentdf/client-go/tdf3"
+)
+
+const testAccessToken = "test-access-token"
+
+// testKAS is a stand-in KAS that serves its public key and rewraps payload keys for
+// any request with a valid policy binding and the test access token - or, if
+// boundTokens is set, the token boundTestToken makes for the client public key.
+type testKAS struct {
+	*httptest.Server
+	key         *rsa.PrivateKey
+	rewraps     int64
+	boundTokens bool
+}
+
+// boundTestToken is the access token bound to clientPublicKeyPEM.
+func boundTestToken(clientPublicKeyPEM string) string {
+	digest := sha256.Sum256([]byte(clientPublicKeyPEM))
+	return testAccessToken + "." + base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func newTestKAS(t *testing.T) *testKAS {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	kas := &testKAS{key: key}
+	kas.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kas_public_key":
+			json.NewEncoder(w).Encode(pubPEM) //nolint:errcheck
+		case "/v2/rewrap":
+			atomic.AddInt64(&kas.rewraps, 1)
+			kas.rewrap(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(kas.Close)
+	return kas
+}
+
+func (kas *testKAS) rewrap(w http.ResponseWriter, r *http.Request) {
+	if !kas.boundTokens && r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+		http.Error(w, "bad access token", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		SignedRequestToken string `json:"signedRequestToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(req.SignedRequestToken, ".")
+	if len(parts) != 3 {
+		http.Error(w, "bad signed request token", http.StatusBadRequest)
+		return
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var claims struct {
+		RequestBody string `json:"requestBody"`
+	}
+	var body kasRewrapRequestBody
+	if json.Unmarshal(claimsJSON, &claims) != nil || json.Unmarshal([]byte(claims.RequestBody), &body) != nil {
+		http.Error(w, "bad request body", http.StatusBadRequest)
+		return
+	}
+	if kas.boundTokens && r.Header.Get("Authorization") != "Bearer "+boundTestToken(body.ClientPublicKey) {
+		http.Error(w, "access token not bound to the client public key", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := tdf3.UnwrapKey(kas.key, body.KeyAccess.WrappedKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tdf3.PolicyBinding(key, body.Policy) != body.KeyAccess.PolicyBinding {
+		http.Error(w, "policy binding mismatch", http.StatusForbidden)
+		return
+	}
+	block, _ := pem.Decode([]byte(body.ClientPublicKey))
+	if block == nil {
+		http.Error(w, "bad client public key", http.StatusBadRequest)
+		return
+	}
+	clientPub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wrapped, err := tdf3.WrapKey(clientPub.(*rsa.PublicKey), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(kasRewrapResponse{EntityWrappedKey: wrapped}) //nolint:errcheck
+}
+
+// newTestIdP is a stand-in IdP that issues the test access token for the
+// client_credentials grant, to requests carrying the client public key.
+func newTestIdP(t *testing.T) *httptest.Server {
+	t.Helper()
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/realms/tdf/protocol/openid-connect/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("grant_type") != grantTypeClientCredentials || r.Header.Get("X-VirtruPubKey") == "" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(oidcTokenResponse{AccessToken: testAccessToken, TokenType: "Bearer", ExpiresIn: 300}) //nolint:errcheck
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// newTestNativeClient returns a native client that authenticates to idp and encrypts for kas.
+func newTestNativeClient(t *testing.T, idp *httptest.Server, kas *testKAS, opts ...Option) TDFClient {
+	t.Helper()
+	opts = append([]Option{WithBackend(BackendNative), WithClientCredentials("tdf", "tdf-client", "secret"),
+		WithOIDCURL(idp.URL), WithKASURL(kas.URL)}, opts...)
+	tdfClient, err := NewTDFClient(opts...)
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	t.Cleanup(tdfClient.Close)
+	return tdfClient
+}
+
+// readTestTDF returns the policy of tdf and its metadata, decrypted with the payload key unwrapped by kas.
+func readTestTDF(kas *testKAS, tdf []byte) (*tdf3.Policy, string, error) {
+	reader, err := tdf3.NewReader(bytes.NewReader(tdf), int64(len(tdf)))
+	if err != nil {
+		return nil, "", err
+	}
+	policy, err := reader.Policy()
+	if err != nil {
+		return nil, "", err
+	}
+	kao := reader.KeyAccess()[0]
+	key, err := tdf3.UnwrapKey(kas.key, kao.WrappedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	metadata, err := tdf3.DecryptMetadata(key, kao.EncryptedMetadata)
+	if err != nil {
+		return nil, "", err
+	}
+	return policy, metadata, nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Keep the refresh tokens of interactive logins (WithDeviceCode or WithAuthorizationCode)
+// in path, so later runs can skip logging in until the IdP stops accepting them. The file
+// is created with owner only permissions, and may be shared by clients of different IdPs
+// and client IDs. Access tokens aren't kept, since they are bound to the client's key
+// pair, which is new for every client. See DefaultTokenCacheFile.
+func WithTokenCache(path string) Option {
+	return func(cfg *clientConfig) {
+		cfg.tokenCacheFile = path
+	}
+}
+
+// DefaultTokenCacheFile returns the conventional place for a WithTokenCache file, in the
+// user's cache directory.
+func DefaultTokenCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "opentdf", "tokens.json"), nil
+}
+
+// tokenCache is one entry, for one token endpoint and client ID, of a token cache file.
+type tokenCache struct {
+	path   string
+	key    string
+	logger *zap.SugaredLogger
+}
+
+type cachedToken struct {
+	RefreshToken  string    `json:"refresh_token"`
+	RefreshExpiry time.Time `json:"refresh_expiry,omitempty"`
+}
+
+func newTokenCache(path, tokenURL, clientId string, logger *zap.SugaredLogger) *tokenCache {
+	return &tokenCache{path: path, key: tokenURL + " " + clientId, logger: logger}
+}
+
+func (cache *tokenCache) readAll() (map[string]cachedToken, error) {
+	entries := make(map[string]cachedToken)
+	data, err := os.ReadFile(cache.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (cache *tokenCache) load() cachedToken {
+	entries, err := cache.readAll()
+	if err != nil {
+		cache.logger.Warnf("Ignoring unreadable token cache %s! Error was %s", cache.path, err)
+		return cachedToken{}
+	}
+	return entries[cache.key]
+}
+
+// store replaces this cache's entry, deleting it if token has no refresh token. A cache
+// that can't be written only means logging in again, so errors are just logged.
+func (cache *tokenCache) store(token cachedToken) {
+	if err := cache.write(token); err != nil {
+		cache.logger.Warnf("Error writing token cache %s! Error was %s", cache.path, err)
+	}
+}
+
+func (cache *tokenCache) write(token cachedToken) error {
+	entries, err := cache.readAll()
+	if err != nil {
+		//Start over rather than keep failing on a corrupt file
+		entries = make(map[string]cachedToken)
+	}
+	if token.RefreshToken == "" {
+		if _, ok := entries[cache.key]; !ok {
+			return nil
+		}
+		delete(entries, cache.key)
+	} else {
+		entries[cache.key] = token
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(cache.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	//Written to a temporary file and renamed, so readers never see half a file
+	f, err := os.CreateTemp(dir, ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cache.path)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// Authenticate to KAS with access tokens from tokenSource, rather than having the client
+// fetch them from an IdP, so WithOIDCURL isn't needed. The client calls tokenSource.Token
+// each time it needs a bearer token, so it should cache tokens until they expire, as
+// oauth2.ReuseTokenSource does. Tokens can't bind the client public key, since Token
+// doesn't take it - use WithBoundTokenSource if KAS checks for that.
+// Only supported by the native backend.
+func WithTokenSource(tokenSource oauth2.TokenSource) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthTokenSource
+		cfg.tokenSource, cfg.boundTokenSource = tokenSource, nil
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+	}
+}
+
+// BoundTokenFunc returns an access token for KAS that binds clientPublicKeyPEM, the
+// PEM public key of the key pair the client has KAS rewrap payload keys to.
+type BoundTokenFunc func(ctx context.Context, clientPublicKeyPEM string) (*oauth2.Token, error)
+
+// Authenticate to KAS with access tokens from tokenFunc, like WithTokenSource, for
+// token sources that bind the client public key into their tokens. The key is the
+// same for every call, and tokenFunc is called each time the client needs a bearer
+// token, so it should cache tokens until they expire. Only supported by the native backend.
+func WithBoundTokenSource(tokenFunc BoundTokenFunc) Option {
+	return func(cfg *clientConfig) {
+		cfg.authMode = AuthTokenSource
+		cfg.tokenSource, cfg.boundTokenSource = nil, tokenFunc
+		cfg.clientSecret, cfg.externalAccessToken = "", ""
+	}
+}
+
+// Creates a new native TDF client that authenticates to KAS with access tokens from tokenSource.
+func NewTDFClientTokenSource(tokenSource oauth2.TokenSource, kasURL string, logger *zap.Logger) (TDFClient, error) {
+	return NewTDFClient(WithTokenSource(tokenSource), WithKASURL(kasURL), WithLogger(logger))
+}
+
+// tokenSourceCredentials gets the native client's access tokens from an oauth2.TokenSource,
+// or a BoundTokenFunc if bound is set.
+type tokenSourceCredentials struct {
+	source oauth2.TokenSource
+	bound  BoundTokenFunc
+}
+
+func (creds *tokenSourceCredentials) token(ctx context.Context, clientPublicKeyPEM string) (string, error) {
+	type result struct {
+		token *oauth2.Token
+		err   error
+	}
+	var res result
+	if creds.bound != nil {
+		res.token, res.err = creds.bound(ctx, clientPublicKeyPEM)
+	} else {
+		//Token takes no context, so a slow source is left to finish in the background
+		done := make(chan result, 1)
+		go func() {
+			token, err := creds.source.Token()
+			done <- result{token, err}
+		}()
+
+		select {
+		case res = <-done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	if res.err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(res.err, &retrieveErr) && retrieveErr.Response != nil {
+			tdfErr := newHTTPStatusError("token source", retrieveErr.Response.StatusCode).(*TDFError)
+			tdfErr.Err = res.err
+			return "", tdfErr
+		}
+		return "", &TDFError{Kind: ErrFailure, Op: "token source", Err: res.err}
+	}
+	if !res.token.Valid() {
+		return "", &TDFError{Kind: ErrAccessDenied, Op: "token source", Err: errors.New("token source returned an empty or expired token")}
+	}
+	return res.token.AccessToken, nil
+}
+
+// The token source decides when its tokens need replacing, and isn't told KAS
+// rejected one, so asking it again would only get the same token.
+func (creds *tokenSourceCredentials) expire(accessToken string) bool {
+	return false
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestBoundTokenSource(t *testing.T) {
+	kas := newTestKAS(t)
+	kas.boundTokens = true
+	var calls int64
+	var clientKeys []string
+	tdfClient, err := NewTDFClient(WithBoundTokenSource(func(ctx context.Context, clientPublicKeyPEM string) (*oauth2.Token, error) {
+		atomic.AddInt64(&calls, 1)
+		clientKeys = append(clientKeys, clientPublicKeyPEM)
+		return &oauth2.Token{AccessToken: boundTestToken(clientPublicKeyPEM)}, nil
+	}), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	defer tdfClient.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := encryptAndCheck(context.Background(), tdfClient, kas, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := atomic.LoadInt64(&calls); calls != 2 || clientKeys[0] == "" || clientKeys[0] != clientKeys[1] {
+		t.Errorf("token func called %d times with keys %q, want twice with the same key", calls, clientKeys)
+	}
+}
+
+// staticTokenSource counts its calls, and always returns the same token.
+type staticTokenSource struct {
+	token string
+	calls int64
+}
+
+func (source *staticTokenSource) Token() (*oauth2.Token, error) {
+	atomic.AddInt64(&source.calls, 1)
+	return &oauth2.Token{AccessToken: source.token}, nil
+}
+
+func TestTokenSourceNotBound(t *testing.T) {
+	kas := newTestKAS(t)
+	kas.boundTokens = true
+	source := &staticTokenSource{token: testAccessToken}
+	tdfClient, err := NewTDFClient(WithTokenSource(source), WithKASURL(kas.URL))
+	if err != nil {
+		t.Fatalf("NewTDFClient() error = %v", err)
+	}
+	defer tdfClient.Close()
+
+	storage, err := NewTDFStorageString("plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	tdf, err := tdfClient.EncryptWithOptions(context.Background(), storage, EncryptOptions{})
+	if err != nil {
+		t.Fatalf("EncryptWithOptions() error = %v", err)
+	}
+	tdfStorage, err := NewTDFStorageBytes(tdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdfStorage.Close()
+	if _, err := tdfClient.DecryptBytes(context.Background(), tdfStorage); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("DecryptBytes() error = %v, want ErrAccessDenied", err)
+	}
+	// The source would only return the same token again, so a 401 isn't retried
+	if calls, rewraps := atomic.LoadInt64(&source.calls), atomic.LoadInt64(&kas.rewraps); calls != 1 || rewraps != 1 {
+		t.Errorf("token source called %d times for %d rewraps, want 1 each", calls, rewraps)
+	}
+}